@@ -7,20 +7,29 @@ import (
 	"io"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 
 	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+	tmplfuncs "github.com/fengxsong/toolkit/pkg/template"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/jsonpath"
 )
 
 type bulkRequestOptions struct {
 	*commonOptions
 	concurrency int
 	serial      bool
+	template    bool
+	sets        []string
 }
 
 func newBulkRequestCommand() *cobra.Command {
@@ -39,7 +48,9 @@ func newBulkRequestCommand() *cobra.Command {
 	o.AddFlags(cmd.Flags())
 	cmd.MarkFlagRequired("es-url")
 	cmd.Flags().IntVarP(&o.concurrency, "concurrency", "c", runtime.NumCPU(), "Concurrency number")
-	cmd.Flags().BoolVar(&o.serial, "serial", false, "Serial execution, parallel by default")
+	cmd.Flags().BoolVar(&o.serial, "serial", false, "Serial execution, parallel by default, requests are always executed serially if any of them captures values")
+	cmd.Flags().BoolVarP(&o.template, "template", "t", false, "Render URLs and bodies as go templates with variables and captured values, enabled if any variable is set")
+	cmd.Flags().StringArrayVar(&o.sets, "set", nil, "Variable in format of key=value for templates, can be specified multiple times")
 	return cmd
 }
 
@@ -47,16 +58,194 @@ type Request struct {
 	Method  string `json:"method" yaml:"method"`
 	URLPath string `json:"url" yaml:"url"`
 	Body    string `json:"body" yaml:"body"`
+	// Expect asserts on status code and values of response
+	Expect *Expectation `json:"expect,omitempty" yaml:"expect,omitempty"`
+	// Capture saves values of response by JSONPath as variables for later requests
+	Capture map[string]string `json:"capture,omitempty" yaml:"capture,omitempty"`
 }
 
-func (r *Request) doWithClient(c *client) ([]byte, error) {
+// Expectation of response, status code must be less than 400 if Status is unset
+type Expectation struct {
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// JSON maps JSONPath like `.hits.total.value` to the expected value
+	JSON map[string]interface{} `json:"json,omitempty" yaml:"json,omitempty"`
+}
+
+func (r *Request) String() string {
+	return r.Method + " " + r.URLPath
+}
+
+// doWithClient performs request, URL and body are rendered with vars if render is
+// true. Values are captured into vars if it's not nil.
+func (r *Request) doWithClient(c *client, vars *variables, render bool) error {
+	urlPath, body := r.URLPath, r.Body
+	if render {
+		var err error
+		if urlPath, err = vars.render(urlPath); err != nil {
+			return fmt.Errorf("%s: render url: %v", r, err)
+		}
+		if body, err = vars.render(body); err != nil {
+			return fmt.Errorf("%s: render body: %v", r, err)
+		}
+	}
 	uri := *c.esURL
-	uri.Path = r.URLPath
+	uri.Path = urlPath
+	if i := strings.Index(urlPath, "?"); i >= 0 {
+		uri.Path, uri.RawQuery = urlPath[:i], urlPath[i+1:]
+	}
 	var data io.Reader
-	if len(r.Body) > 0 {
-		data = bytes.NewReader([]byte(r.Body))
+	if len(body) > 0 {
+		data = bytes.NewReader([]byte(body))
+	}
+	status, respBody, err := c.doRequestWithStatus(r.Method, uri.String(), "application/json", data, c.dryRun)
+	if err != nil {
+		return fmt.Errorf("%s: %v", r, err)
+	}
+	if c.dryRun {
+		if vars == nil {
+			return nil
+		}
+		// captured values are unknown, placeholders keep later requests renderable
+		for name := range r.Capture {
+			vars.set(name, "<"+name+">")
+		}
+		return nil
+	}
+	if err = r.check(status, respBody); err != nil {
+		return fmt.Errorf("%s: %v", r, err)
+	}
+	if len(r.Capture) == 0 {
+		return nil
+	}
+	var resp interface{}
+	if err = json.Unmarshal(respBody, &resp); err != nil {
+		return fmt.Errorf("%s: capture: %v", r, err)
+	}
+	for name, path := range r.Capture {
+		v, err := jsonPathValue(resp, path)
+		if err != nil {
+			return fmt.Errorf("%s: capture %s: %v", r, name, err)
+		}
+		vars.set(name, v)
+	}
+	return nil
+}
+
+// check asserts on status code and values of response
+func (r *Request) check(status int, body []byte) error {
+	if r.Expect == nil || r.Expect.Status == 0 {
+		if status/100 >= 4 {
+			return fmt.Errorf("unexpected error: %s", string(body))
+		}
+	} else if status != r.Expect.Status {
+		return fmt.Errorf("expected status %d, got %d: %s", r.Expect.Status, status, string(body))
+	}
+	if r.Expect == nil || len(r.Expect.JSON) == 0 {
+		return nil
+	}
+	var resp interface{}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("decode response: %v", err)
 	}
-	return c.doRequest(r.Method, uri.String(), data, c.dryRun)
+	paths := make([]string, 0, len(r.Expect.JSON))
+	for path := range r.Expect.JSON {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var errs []error
+	for _, path := range paths {
+		actual, err := jsonPathValue(resp, path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+			continue
+		}
+		// values decoded from yaml are normalized to be comparable with json
+		b, err := json.Marshal(r.Expect.JSON[path])
+		if err != nil {
+			return err
+		}
+		var expected interface{}
+		json.Unmarshal(b, &expected)
+		if !reflect.DeepEqual(actual, expected) {
+			a, _ := json.Marshal(actual)
+			errs = append(errs, fmt.Errorf("%s: expected %s, got %s", path, b, a))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.MultiError(errs)
+	}
+	return nil
+}
+
+// jsonPathValue returns value at JSONPath of data, braces of path are optional.
+// Values are returned in slice if path matches multiple values.
+func jsonPathValue(data interface{}, path string) (interface{}, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	jp := jsonpath.New("path")
+	if err := jp.Parse(path); err != nil {
+		return nil, err
+	}
+	results, err := jp.FindResults(data)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, rs := range results {
+		for _, v := range rs {
+			values = append(values, v.Interface())
+		}
+	}
+	switch len(values) {
+	case 0:
+		return nil, fmt.Errorf("no value found")
+	case 1:
+		return values[0], nil
+	}
+	return values, nil
+}
+
+// variables of templates, including values set by flags and captured from responses
+type variables struct {
+	mu sync.RWMutex
+	m  map[string]interface{}
+}
+
+func newVariables(sets []string) (*variables, error) {
+	v := &variables{m: make(map[string]interface{})}
+	for _, s := range sets {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid variable: %s", s)
+		}
+		v.m[kv[0]] = kv[1]
+	}
+	return v, nil
+}
+
+func (v *variables) set(name string, val interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.m[name] = val
+}
+
+// render executes s as template, referring to undefined variables is an error
+func (v *variables) render(s string) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+	t, err := template.New("request").Funcs(tmplfuncs.FuncMap()).Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	buf := bytes.NewBuffer(nil)
+	if err = t.Execute(buf, v.m); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 func parseFile(fn string) ([]*Request, error) {
@@ -95,7 +284,7 @@ func removeEmptyLineAndComments(body []byte) []byte {
 func parseListFile(data []byte) ([]*Request, error) {
 	data = removeEmptyLineAndComments(data)
 	splits := bytes.Split(data, []byte("---\n"))
-	reg := regexp.MustCompile(`([a-zA-Z]+)\s+(\S+)`)
+	reg := regexp.MustCompile(`([a-zA-Z]+)\s+(\S.*?)\s*$`)
 	var requests []*Request
 	for i := range splits {
 		req, err := parseRaw(bytes.TrimLeft(bytes.TrimRight(splits[i], " "), " "), reg)
@@ -107,17 +296,98 @@ func parseListFile(data []byte) ([]*Request, error) {
 	return requests, nil
 }
 
+// parseRaw parses request in format of
+//
+//	METHOD /url/path
+//	@status 200
+//	@expect .acknowledged true
+//	@capture name .json.path
+//	body
+//
+// lines of directives are optional and follow the first line, body starts from
+// the first line that is not a directive. Value of @expect is JSON or a string.
 func parseRaw(data []byte, firstLineReg *regexp.Regexp) (*Request, error) {
 	splits := bytes.SplitN(data, []byte("\n"), 2)
 	matches := firstLineReg.FindStringSubmatch(string(splits[0]))
 	if len(matches) != 3 {
 		return nil, fmt.Errorf("invalid format: %s", splits[0])
 	}
-	return &Request{
+	r := &Request{
 		Method:  matches[1],
 		URLPath: matches[2],
-		Body:    strings.TrimLeft(strings.TrimRight(string(splits[1]), " "), " "),
-	}, nil
+	}
+	var rest []byte
+	if len(splits) == 2 {
+		rest = splits[1]
+	}
+	for isDirective(rest) {
+		lines := bytes.SplitN(rest, []byte("\n"), 2)
+		if err := r.parseDirective(string(bytes.TrimSpace(lines[0]))); err != nil {
+			return nil, err
+		}
+		rest = nil
+		if len(lines) == 2 {
+			rest = lines[1]
+		}
+	}
+	r.Body = strings.TrimLeft(strings.TrimRight(string(rest), " "), " ")
+	return r, nil
+}
+
+var directives = []string{"@status", "@expect", "@capture"}
+
+// isDirective reports whether the first line of data is a directive
+func isDirective(data []byte) bool {
+	line := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		line = data[:i]
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return false
+	}
+	for _, d := range directives {
+		if fields[0] == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Request) parseDirective(line string) error {
+	fields := strings.Fields(line)
+	switch {
+	case fields[0] == "@status" && len(fields) == 2:
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid status: %s", line)
+		}
+		if r.Expect == nil {
+			r.Expect = &Expectation{}
+		}
+		r.Expect.Status = status
+	case fields[0] == "@expect" && len(fields) >= 3:
+		raw := strings.Join(fields[2:], " ")
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			v = raw
+		}
+		if r.Expect == nil {
+			r.Expect = &Expectation{}
+		}
+		if r.Expect.JSON == nil {
+			r.Expect.JSON = make(map[string]interface{})
+		}
+		r.Expect.JSON[fields[1]] = v
+	case fields[0] == "@capture" && len(fields) == 3:
+		if r.Capture == nil {
+			r.Capture = make(map[string]string)
+		}
+		r.Capture[fields[1]] = fields[2]
+	default:
+		return fmt.Errorf("invalid directive: %s", line)
+	}
+	return nil
 }
 
 func (o *bulkRequestOptions) Run(files ...string) error {
@@ -125,32 +395,55 @@ func (o *bulkRequestOptions) Run(files ...string) error {
 	if err != nil {
 		return err
 	}
+	var errs []error
+	var requests []*Request
+	var capturing bool
+	for i := range files {
+		rs, err := parseFile(files[i])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", files[i], err))
+			continue
+		}
+		for _, r := range rs {
+			capturing = capturing || len(r.Capture) > 0
+		}
+		requests = append(requests, rs...)
+	}
+	// templating is never implied by captures, bodies like search templates have
+	// mustaches of their own
+	render := o.template || len(o.sets) > 0
+	if capturing && !render {
+		log.GetLogger().Warn("requests capture values, which are only referred to with --template")
+	}
+	var vars *variables
+	if render || capturing {
+		if vars, err = newVariables(o.sets); err != nil {
+			return err
+		}
+	}
+	serial := o.serial
+	if capturing && !serial {
+		log.GetLogger().Info("requests capture values, executing serially")
+		serial = true
+	}
 	errCh := make(chan error, o.concurrency)
 	doRequest := func(r *Request, wg *sync.WaitGroup) {
 		defer wg.Done()
-		_, err := r.doWithClient(cli)
-		errCh <- err
+		errCh <- r.doWithClient(cli, vars, render)
 	}
 	go func() {
 		wg := &sync.WaitGroup{}
-		for i := range files {
-			requests, err := parseFile(files[i])
-			if err != nil {
-				errCh <- err
-			}
-			for j := range requests {
-				wg.Add(1)
-				if o.serial {
-					doRequest(requests[j], wg)
-				} else {
-					go doRequest(requests[j], wg)
-				}
+		for j := range requests {
+			wg.Add(1)
+			if serial {
+				doRequest(requests[j], wg)
+			} else {
+				go doRequest(requests[j], wg)
 			}
 		}
 		wg.Wait()
 		close(errCh)
 	}()
-	var errs []error
 	for e := range errCh {
 		if e != nil {
 			errs = append(errs, e)
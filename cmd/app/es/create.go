@@ -1,13 +1,10 @@
 package es
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
-	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -29,6 +26,8 @@ type createOptions struct {
 	skipDotPrefix bool
 	override      bool
 	refresh       bool
+	strategies    []string
+	patternRegex  string
 }
 
 func (o *createOptions) setDefaults() {
@@ -60,12 +59,18 @@ func newCreatePatternCommand() *cobra.Command {
 	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regexp pattern to exclude")
 	cmd.Flags().BoolVar(&o.override, "override", false, "Overrides an existing index pattern if an index pattern with the provided title already exists")
 	cmd.Flags().BoolVar(&o.refresh, "refresh", false, "Reloads index pattern fields after the index pattern is stored")
+	cmd.Flags().StringSliceVar(&o.strategies, "strategy", []string{strategyDaily}, "Strategies for deriving index patterns from index names, any of daily(app-2021.09.01 or app-20210901), monthly(app-2021.09), rollover(app-000012) and datastream(data streams by name)")
+	cmd.Flags().StringVar(&o.patternRegex, "pattern-regex", "", "Regexp whose first submatch of index name is the name of index pattern, it takes precedence over strategies")
 	cmd.Flags().BoolVar(&o.skipDotPrefix, "skip-dot-prefix", true, "Skip indices with `.` prefix")
 	cmd.Flags().MarkHidden("skip-dot-prefix")
 	return cmd
 }
 
 func (o *createOptions) Run() (err error) {
+	strategies, withDataStreams, err := parseStrategies(o.strategies, o.patternRegex)
+	if err != nil {
+		return err
+	}
 	cli, err := o.commonOptions.complete()
 	if err != nil {
 		return err
@@ -78,15 +83,38 @@ func (o *createOptions) Run() (err error) {
 	if len(o.exclude) > 0 {
 		excludePatternReg = regexp.MustCompile(o.exclude)
 	}
-	indicePatterns, err := cli.listIndices(o.skipDotPrefix, filterPatternReg, excludePatternReg)
+	indicePatterns, err := cli.listIndices(o.skipDotPrefix, filterPatternReg, excludePatternReg, strategies)
 	if err != nil {
 		return fmt.Errorf("fetch indices: %s", err)
 	}
-	for i := range indicePatterns {
-		if err = cli.createPattern(o.namespace, indicePatterns[i], o.tsFieldName, o.override, o.refresh); err != nil {
+	for _, p := range indicePatterns {
+		p.TimeFieldName = o.tsFieldName
+	}
+	if withDataStreams {
+		dataStreams, err := cli.listDataStreams()
+		if err != nil {
+			return fmt.Errorf("fetch data streams: %s", err)
+		}
+		for _, ds := range dataStreams {
+			if (o.skipDotPrefix && strings.HasPrefix(ds.Name, ".")) ||
+				(filterPatternReg != nil && !filterPatternReg.MatchString(ds.Name)) ||
+				(excludePatternReg != nil && excludePatternReg.MatchString(ds.Name)) {
+				continue
+			}
+			tsFieldName := ds.TimestampField.Name
+			if tsFieldName == "" {
+				tsFieldName = o.tsFieldName
+			}
+			// ID is prefixed as data stream `app` and daily indices `app-2021.09.01`
+			// would otherwise derive the same ID
+			indicePatterns = append(indicePatterns, &indexPattern{ID: dataStreamPatternIDPrefix + ds.Name, Title: ds.Name, TimeFieldName: tsFieldName})
+		}
+	}
+	for _, p := range indicePatterns {
+		if err = cli.createIndexPattern(o.namespace, p, o.override, o.refresh); err != nil {
 			return err
 		}
-		log.GetLogger().Infof("create index pattern for %s", indicePatterns[i])
+		log.GetLogger().Infof("create index pattern %s", p.Title)
 	}
 	return nil
 }
@@ -97,29 +125,22 @@ func (c *client) setBasicAuthIfRequired(req *http.Request) {
 	}
 }
 
-func (c *client) listIndices(skipDotPrefix bool, filterPatternReg, excludePatternReg *regexp.Regexp) ([]string, error) {
-	c.esURL.Path = "/_cat/indices"
-	c.esURL.RawQuery = url.Values{"format": []string{"json"}}.Encode()
+// listIndices returns index patterns named by the first strategy that matches
+// open indices, time field of index patterns is not set
+func (c *client) listIndices(skipDotPrefix bool, filterPatternReg, excludePatternReg *regexp.Regexp, strategies []*indexStrategy) ([]*indexPattern, error) {
+	var expr string
 	if filterPatternReg != nil {
 		pt := filterPatternReg.String()
 		if strings.HasPrefix(pt, "^") {
-			c.esURL.Path = path.Join(c.esURL.Path, strings.TrimPrefix(pt, "^"))
+			expr = strings.TrimPrefix(pt, "^")
 		}
 	}
-	respBody, err := c.doRequest(http.MethodGet, c.esURL.String(), nil, false)
+	indices, err := c.catIndices(expr)
 	if err != nil {
 		return nil, err
 	}
-	var indices []struct {
-		Status string `json:"status"`
-		Index  string `json:"index"`
-	}
-	if err = json.Unmarshal(respBody, &indices); err != nil {
-		return nil, err
-	}
 	temp := make(map[string]struct{})
-	parsePatternReg := regexp.MustCompile(`([a-zA-Z0-9_-]*)-\d{4}.\d{2}.\d{2}`)
-
+	var unmatched int
 	for _, indice := range indices {
 		if indice.Status != "open" || (skipDotPrefix && strings.HasPrefix(indice.Index, ".")) {
 			continue
@@ -135,34 +156,30 @@ func (c *client) listIndices(skipDotPrefix bool, filterPatternReg, excludePatter
 			log.GetLogger().Debugf("skip pattern %s", indice.Index)
 			continue
 		}
-		ret := parsePatternReg.FindStringSubmatch(indice.Index)
-		if len(ret) != 2 || len(ret[1]) == 0 {
-			continue
+		var matched bool
+		for _, s := range strategies {
+			if pattern, ok := s.parse(indice.Index); ok {
+				temp[pattern] = struct{}{}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched++
+			log.GetLogger().Debugf("index %s matches no strategy", indice.Index)
 		}
-		temp[ret[1]] = struct{}{}
 	}
-	patterns := make([]string, 0, len(temp))
-	for k := range temp {
-		patterns = append(patterns, k)
+	if unmatched > 0 {
+		log.GetLogger().Infof("skip %d index(es) matching no strategy", unmatched)
 	}
-	return patterns, nil
-}
-
-func (c *client) createPattern(namespace, s, tsFieldName string, override, refresh bool) error {
-	c.kibanaURL.Path = fmt.Sprintf("/s/%s/api/index_patterns/index_pattern", namespace)
-	pl := map[string]interface{}{
-		"override":       override,
-		"refresh_fields": refresh,
-		"index_pattern": map[string]string{
-			"id":            s,
-			"title":         fmt.Sprintf("%s-*", s),
-			"timeFieldName": tsFieldName,
-		},
+	names := make([]string, 0, len(temp))
+	for k := range temp {
+		names = append(names, k)
 	}
-	b, err := json.Marshal(&pl)
-	if err != nil {
-		return err
+	sort.Strings(names)
+	patterns := make([]*indexPattern, 0, len(names))
+	for _, name := range names {
+		patterns = append(patterns, &indexPattern{ID: name, Title: fmt.Sprintf("%s-*", name)})
 	}
-	_, err = c.doRequest(http.MethodPost, c.kibanaURL.String(), bytes.NewReader(b), c.dryRun)
-	return err
+	return patterns, nil
 }
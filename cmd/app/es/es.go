@@ -97,6 +97,23 @@ func (c *client) validate() error {
 }
 
 func (c *client) doRequest(method, url string, data io.Reader, dryRun bool) (body []byte, err error) {
+	return c.doRequestWithContentType(method, url, "application/json", data, dryRun)
+}
+
+func (c *client) doRequestWithContentType(method, url, contentType string, data io.Reader, dryRun bool) (body []byte, err error) {
+	status, body, err := c.doRequestWithStatus(method, url, contentType, data, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	if status/100 >= 4 {
+		return nil, fmt.Errorf("unexpected error: %s", string(body))
+	}
+	return body, nil
+}
+
+// doRequestWithStatus returns status code and body of response whatever the
+// status code is, status code is 0 in dry-run mode
+func (c *client) doRequestWithStatus(method, url, contentType string, data io.Reader, dryRun bool) (status int, body []byte, err error) {
 	start := time.Now()
 	defer func() {
 		log.GetLogger().Debugw("do request",
@@ -104,20 +121,21 @@ func (c *client) doRequest(method, url string, data io.Reader, dryRun bool) (bod
 			"url", url,
 			"dry-run", dryRun,
 			"duration", time.Since(start).String(),
+			"status", status,
 			"body", string(body),
 		)
 	}()
 	req, err := http.NewRequest(method, url, data)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	if dryRun {
-		return nil, nil
+		return 0, nil, nil
 	}
 	req.Header.Add("kbn-version", c.kbnVer)
 	c.setBasicAuthIfRequired(req)
 	if data != nil {
-		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Type", contentType)
 	}
 
 	delay := 500 * time.Microsecond
@@ -133,17 +151,14 @@ func (c *client) doRequest(method, url string, data io.Reader, dryRun bool) (bod
 		delay *= 2
 	}
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 	body, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
-	}
-	if resp.StatusCode/100 >= 4 {
-		return nil, fmt.Errorf("unexpected error: %s", string(body))
+		return 0, nil, err
 	}
-	return body, nil
+	return resp.StatusCode, body, nil
 }
 
 func newSubCommand() *cobra.Command {
@@ -154,5 +169,8 @@ func newSubCommand() *cobra.Command {
 	cmd.AddCommand(newCreatePatternCommand())
 	cmd.AddCommand(newDeletePatternCommand())
 	cmd.AddCommand(newBulkRequestCommand())
+	cmd.AddCommand(newIndicesCommand())
+	cmd.AddCommand(newKibanaCommand())
+	cmd.AddCommand(newPatternsCommand())
 	return cmd
 }
@@ -0,0 +1,194 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+type gcPatternsOptions struct {
+	*commonOptions
+	namespace string
+	exclude   string
+	delete    bool
+}
+
+func newGCPatternsCommand() *cobra.Command {
+	o := &gcPatternsOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Report or delete index patterns that match no open index",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run(os.Stdout)
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("es-url")
+	cmd.MarkFlagRequired("kibana-url")
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", "default", "Kibana namespace")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regexp pattern of titles of index patterns to keep")
+	cmd.Flags().BoolVar(&o.delete, "delete", false, "Delete orphaned index patterns, they are only reported by default")
+	return cmd
+}
+
+func (o *gcPatternsOptions) Run(out io.Writer) (err error) {
+	var excludePatternReg *regexp.Regexp
+	if len(o.exclude) > 0 {
+		if excludePatternReg, err = regexp.Compile(o.exclude); err != nil {
+			return err
+		}
+	}
+	cli, err := o.complete()
+	if err != nil {
+		return err
+	}
+	names, err := cli.openIndexNames()
+	if err != nil {
+		return fmt.Errorf("fetch indices: %s", err)
+	}
+	patterns, err := cli.findIndexPatterns(o.namespace)
+	if err != nil {
+		return fmt.Errorf("find index patterns: %s", err)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		return patterns[i].Attributes.Title < patterns[j].Attributes.Title
+	})
+
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(tw, "ID\tTITLE\tRESULT")
+	var orphaned int
+	var errs []error
+	for _, p := range patterns {
+		title := p.Attributes.Title
+		if excludePatternReg != nil && excludePatternReg.MatchString(title) {
+			continue
+		}
+		if !orphanedPattern(title, names) {
+			continue
+		}
+		orphaned++
+		result := "orphaned"
+		if o.delete {
+			result = "deleted"
+			if o.dryRun {
+				result = "dry-run"
+			}
+			if err = cli.deletePattern(o.namespace, p.ID); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", title, err))
+				result = "failed"
+			} else if !o.dryRun {
+				log.GetLogger().Infof("index pattern `%s` has been removed", title)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.ID, title, result)
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\n%d of %d index pattern(s) in space %s match no open index\n", orphaned, len(patterns), o.namespace)
+	if len(errs) > 0 {
+		return errors.MultiError(errs)
+	}
+	return nil
+}
+
+// openIndexNames returns names of open indices, their aliases and data streams
+func (c *client) openIndexNames() ([]string, error) {
+	indices, err := c.catIndices("")
+	if err != nil {
+		return nil, err
+	}
+	open := make(map[string]bool, len(indices))
+	names := make([]string, 0, len(indices))
+	for _, index := range indices {
+		if index.Status == "open" {
+			open[index.Index] = true
+			names = append(names, index.Index)
+		}
+	}
+	uri := *c.esURL
+	uri.Path = "/_cat/aliases"
+	uri.RawQuery = url.Values{"format": []string{"json"}}.Encode()
+	body, err := c.doRequest(http.MethodGet, uri.String(), nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var aliases []struct {
+		Alias string `json:"alias"`
+		Index string `json:"index"`
+	}
+	if err = json.Unmarshal(body, &aliases); err != nil {
+		return nil, err
+	}
+	for _, a := range aliases {
+		if open[a.Index] {
+			names = append(names, a.Alias)
+		}
+	}
+	dataStreams, err := c.listDataStreams()
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range dataStreams {
+		names = append(names, ds.Name)
+	}
+	return names, nil
+}
+
+// orphanedPattern reports whether title of index pattern matches none of names.
+// Title is comma separated wildcard expressions, those prefixed with `-` exclude
+// names, and patterns referring to remote clusters are never orphaned as remote
+// indices are unknown.
+func orphanedPattern(title string, names []string) bool {
+	var includes, excludes []*regexp.Regexp
+	for _, expr := range strings.Split(title, ",") {
+		expr = strings.TrimSpace(expr)
+		if expr == "" {
+			continue
+		}
+		if strings.Contains(expr, ":") {
+			return false
+		}
+		exclude := strings.HasPrefix(expr, "-")
+		reg := wildcardRegexp(strings.TrimPrefix(expr, "-"))
+		if exclude {
+			excludes = append(excludes, reg)
+		} else {
+			includes = append(includes, reg)
+		}
+	}
+	for _, name := range names {
+		if matchAny(includes, name) && !matchAny(excludes, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func wildcardRegexp(expr string) *regexp.Regexp {
+	return regexp.MustCompile("^" + strings.ReplaceAll(regexp.QuoteMeta(expr), `\*`, ".*") + "$")
+}
+
+func matchAny(regs []*regexp.Regexp, s string) bool {
+	for _, reg := range regs {
+		if reg.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// dailyIndexReg matches indices with date suffix like `app-2021.09.01`
+var dailyIndexReg = regexp.MustCompile(`([a-zA-Z0-9_-]*)-(\d{4}).(\d{2}).(\d{2})`)
+
+// indexInfo is an index returned by `_cat/indices`, numbers are strings and
+// missing for closed indices
+type indexInfo struct {
+	Health    string `json:"health"`
+	Status    string `json:"status"`
+	Index     string `json:"index"`
+	DocsCount string `json:"docs.count"`
+	StoreSize string `json:"store.size"`
+}
+
+func (i *indexInfo) docs() int64 {
+	n, _ := strconv.ParseInt(i.DocsCount, 10, 64)
+	return n
+}
+
+// size returns store size in bytes
+func (i *indexInfo) size() int64 {
+	n, _ := strconv.ParseInt(i.StoreSize, 10, 64)
+	return n
+}
+
+// parseIndexDate returns pattern and date of daily index, ok is false if index
+// has no date suffix
+func parseIndexDate(index string) (pattern string, date time.Time, ok bool) {
+	ret := dailyIndexReg.FindStringSubmatch(index)
+	if len(ret) != 5 || len(ret[1]) == 0 {
+		return "", time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", ret[2]+"-"+ret[3]+"-"+ret[4])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return ret[1], date, true
+}
+
+// catIndices lists indices matching expr, all indices are listed if expr is empty
+func (c *client) catIndices(expr string) ([]*indexInfo, error) {
+	uri := *c.esURL
+	uri.Path = path.Join("/_cat/indices", expr)
+	uri.RawQuery = url.Values{"format": []string{"json"}, "bytes": []string{"b"}}.Encode()
+	respBody, err := c.doRequest(http.MethodGet, uri.String(), nil, false)
+	if err != nil {
+		return nil, err
+	}
+	var indices []*indexInfo
+	if err = json.Unmarshal(respBody, &indices); err != nil {
+		return nil, err
+	}
+	return indices, nil
+}
+
+// parseAge parses duration which also supports units of day(d) and week(w), eg. 30d
+func parseAge(s string) (time.Duration, error) {
+	for unit, d := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, unit) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			return time.Duration(n * float64(d)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// formatBytes formats size in bytes in human readable format, eg. 1.5gb
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%db", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cb", float64(n)/float64(div), "kmgtpe"[exp])
+}
+
+func newIndicesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "indices",
+		Aliases: []string{"index"},
+		Short:   "Manage indices in elasticsearch",
+	}
+	cmd.AddCommand(newListIndicesCommand())
+	cmd.AddCommand(newPruneIndicesCommand())
+	return cmd
+}
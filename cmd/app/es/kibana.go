@@ -0,0 +1,318 @@
+package es
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+// defaultSavedObjectTypes are types of saved objects to export by default
+var defaultSavedObjectTypes = []string{"dashboard", "visualization", "search", "index-pattern"}
+
+func newKibanaCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kibana",
+		Short: "Export, import or sync kibana saved objects across spaces and clusters",
+	}
+	cmd.AddCommand(newKibanaExportCommand())
+	cmd.AddCommand(newKibanaImportCommand())
+	cmd.AddCommand(newKibanaSyncCommand())
+	return cmd
+}
+
+type kibanaExportOptions struct {
+	*commonOptions
+	namespace string
+	types     []string
+	deep      bool
+	file      string
+}
+
+func newKibanaExportCommand() *cobra.Command {
+	o := &kibanaExportOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export saved objects of kibana space in ndjson",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("kibana-url")
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", "default", "Kibana namespace")
+	cmd.Flags().StringSliceVar(&o.types, "type", defaultSavedObjectTypes, "Types of saved objects to export")
+	cmd.Flags().BoolVar(&o.deep, "include-references", true, "Export objects referenced by exported objects deeply")
+	cmd.Flags().StringVarP(&o.file, "file", "f", "-", "File to write exported objects to, `-` for stdout")
+	return cmd
+}
+
+func (o *kibanaExportOptions) Run() error {
+	cli, err := o.complete()
+	if err != nil {
+		return err
+	}
+	data, err := cli.exportObjects(o.namespace, o.types, o.deep)
+	if err != nil {
+		return err
+	}
+	if o.file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err = ioutil.WriteFile(o.file, data, 0644); err != nil {
+		return err
+	}
+	return printObjectCounts(os.Stdout, data)
+}
+
+type kibanaImportOptions struct {
+	*commonOptions
+	namespace string
+	overwrite bool
+	file      string
+}
+
+func newKibanaImportCommand() *cobra.Command {
+	o := &kibanaImportOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import saved objects in ndjson into kibana space",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("kibana-url")
+	cmd.Flags().StringVarP(&o.namespace, "namespace", "n", "default", "Kibana namespace")
+	cmd.Flags().BoolVar(&o.overwrite, "overwrite", false, "Overwrite saved objects that already exist")
+	cmd.Flags().StringVarP(&o.file, "file", "f", "-", "File of exported objects, `-` for stdin")
+	return cmd
+}
+
+func (o *kibanaImportOptions) Run() error {
+	cli, err := o.complete()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	if o.file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(o.file)
+	}
+	if err != nil {
+		return err
+	}
+	return importAndReport(cli, o.namespace, data, o.overwrite)
+}
+
+type kibanaSyncOptions struct {
+	*commonOptions
+	fromSpace string
+	toSpace   string
+	types     []string
+	overwrite bool
+	// target kibana, it's the same as source if unset
+	toKibanaURL string
+	toUsername  string
+	toPassword  string
+	toVersion   string
+}
+
+func newKibanaSyncCommand() *cobra.Command {
+	o := &kibanaSyncOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Copy saved objects from one kibana space to another, which may be in another kibana",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run()
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("kibana-url")
+	cmd.Flags().StringVar(&o.fromSpace, "from-space", "default", "Kibana namespace to copy saved objects from")
+	cmd.Flags().StringVar(&o.toSpace, "to-space", "", "Kibana namespace to copy saved objects to, defaults to --from-space")
+	cmd.Flags().StringSliceVar(&o.types, "type", defaultSavedObjectTypes, "Types of saved objects to sync")
+	cmd.Flags().BoolVar(&o.overwrite, "overwrite", true, "Overwrite saved objects that already exist in target space")
+	cmd.Flags().StringVar(&o.toKibanaURL, "to-kibana-url", "", "URL of target kibana, defaults to --kibana-url")
+	cmd.Flags().StringVar(&o.toUsername, "to-username", "", "Username of target kibana, defaults to --username")
+	cmd.Flags().StringVar(&o.toPassword, "to-password", "", "Password of target kibana, defaults to --password")
+	cmd.Flags().StringVar(&o.toVersion, "to-kibana-version", "", "Version of target kibana, defaults to --kibana-version")
+	return cmd
+}
+
+func (o *kibanaSyncOptions) Run() error {
+	if o.toSpace == "" {
+		o.toSpace = o.fromSpace
+	}
+	if o.toKibanaURL == "" && o.toSpace == o.fromSpace {
+		return fmt.Errorf("source and target are the same, --to-space or --to-kibana-url is required")
+	}
+	src, err := o.complete()
+	if err != nil {
+		return err
+	}
+	target := *o.commonOptions
+	if o.toKibanaURL != "" {
+		target.kibanaURL = o.toKibanaURL
+	}
+	if o.toUsername != "" {
+		target.username, target.password = o.toUsername, o.toPassword
+	}
+	if o.toVersion != "" {
+		target.kibanaVersion = o.toVersion
+	}
+	target.setDefaults()
+	dst, err := target.complete()
+	if err != nil {
+		return err
+	}
+	data, err := src.exportObjects(o.fromSpace, o.types, true)
+	if err != nil {
+		return err
+	}
+	log.GetLogger().Infof("sync saved objects from %s/s/%s to %s/s/%s", src.kibanaURL, o.fromSpace, dst.kibanaURL, o.toSpace)
+	return importAndReport(dst, o.toSpace, data, o.overwrite)
+}
+
+// exportObjects exports saved objects of types in namespace in ndjson
+func (c *client) exportObjects(namespace string, types []string, deep bool) ([]byte, error) {
+	uri := *c.kibanaURL
+	uri.Path = fmt.Sprintf("/s/%s/api/saved_objects/_export", namespace)
+	b, err := json.Marshal(map[string]interface{}{
+		"type":                  types,
+		"includeReferencesDeep": deep,
+		"excludeExportDetails":  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// exporting changes nothing, so it's done in dry-run mode as well
+	return c.doRequest(http.MethodPost, uri.String(), bytes.NewReader(b), false)
+}
+
+type importResult struct {
+	Success      bool `json:"success"`
+	SuccessCount int  `json:"successCount"`
+	Errors       []struct {
+		ID    string `json:"id"`
+		Type  string `json:"type"`
+		Title string `json:"title"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	} `json:"errors"`
+}
+
+// importObjects imports saved objects in ndjson into namespace
+func (c *client) importObjects(namespace string, data []byte, overwrite bool) (*importResult, error) {
+	uri := *c.kibanaURL
+	uri.Path = fmt.Sprintf("/s/%s/api/saved_objects/_import", namespace)
+	uri.RawQuery = url.Values{"overwrite": []string{strconv.FormatBool(overwrite)}}.Encode()
+	buf := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buf)
+	fw, err := mw.CreateFormFile("file", "export.ndjson")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = mw.Close(); err != nil {
+		return nil, err
+	}
+	body, err := c.doRequestWithContentType(http.MethodPost, uri.String(), mw.FormDataContentType(), buf, c.dryRun)
+	if err != nil || c.dryRun {
+		return nil, err
+	}
+	ret := &importResult{}
+	if err = json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// importAndReport imports saved objects and prints counts of objects by type,
+// objects failed to import are returned as error
+func importAndReport(c *client, namespace string, data []byte, overwrite bool) error {
+	ret, err := c.importObjects(namespace, data, overwrite)
+	if err != nil {
+		return err
+	}
+	if err = printObjectCounts(os.Stdout, data); err != nil {
+		return err
+	}
+	if ret == nil {
+		fmt.Fprintln(os.Stdout, "dry-run, nothing imported")
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "%d object(s) imported, %d failed\n", ret.SuccessCount, len(ret.Errors))
+	if len(ret.Errors) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(ret.Errors))
+	for _, e := range ret.Errors {
+		errs = append(errs, fmt.Errorf("%s %s(%s): %s", e.Type, e.ID, e.Title, e.Error.Type))
+	}
+	return errors.MultiError(errs)
+}
+
+// printObjectCounts prints number of saved objects in ndjson by type
+func printObjectCounts(out io.Writer, data []byte) error {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return fmt.Errorf("invalid saved object: %v", err)
+		}
+		if obj.Type != "" {
+			counts[obj.Type]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(tw, "TYPE\tCOUNT")
+	for _, t := range types {
+		fmt.Fprintf(tw, "%s\t%d\n", t, counts[t])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,202 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+type listOptions struct {
+	*commonOptions
+	filter         string
+	exclude        string
+	groupByPattern bool
+	sortBy         string
+	output         string
+	skipDotPrefix  bool
+}
+
+func newListIndicesCommand() *cobra.Command {
+	o := &listOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List indices with doc count and store size",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run(os.Stdout)
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("es-url")
+	cmd.Flags().StringVarP(&o.filter, "filter", "f", "", "Regexp pattern to filter, usually used to match prefix")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regexp pattern to exclude")
+	cmd.Flags().BoolVarP(&o.groupByPattern, "group-by-pattern", "g", false, "Aggregate daily indices into their pattern with total size and date range")
+	cmd.Flags().StringVar(&o.sortBy, "sort-by", "name", "Sort by one of name, docs and size, sizes and doc counts are sorted in descending order")
+	cmd.Flags().StringVarP(&o.output, "output", "o", "table", "Output format, one of table, json and yaml")
+	cmd.Flags().BoolVar(&o.skipDotPrefix, "skip-dot-prefix", true, "Skip indices with `.` prefix")
+	cmd.Flags().MarkHidden("skip-dot-prefix")
+	return cmd
+}
+
+// indexRow is an index in output
+type indexRow struct {
+	Index  string `json:"index" yaml:"index"`
+	Health string `json:"health" yaml:"health"`
+	Status string `json:"status" yaml:"status"`
+	Docs   int64  `json:"docs" yaml:"docs"`
+	Size   int64  `json:"size" yaml:"size"`
+}
+
+// patternRow is the aggregation of indices of a pattern, indices without date
+// suffix are patterns by themselves and have no date range
+type patternRow struct {
+	Pattern string `json:"pattern" yaml:"pattern"`
+	Indices int    `json:"indices" yaml:"indices"`
+	Docs    int64  `json:"docs" yaml:"docs"`
+	Size    int64  `json:"size" yaml:"size"`
+	From    string `json:"from,omitempty" yaml:"from,omitempty"`
+	To      string `json:"to,omitempty" yaml:"to,omitempty"`
+}
+
+func (o *listOptions) Run(out io.Writer) (err error) {
+	switch o.output {
+	case "table", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown output format: %s", o.output)
+	}
+	switch o.sortBy {
+	case "name", "docs", "size":
+	default:
+		return fmt.Errorf("unknown sort key: %s", o.sortBy)
+	}
+	var filterPatternReg, excludePatternReg *regexp.Regexp
+	if len(o.filter) > 0 {
+		if filterPatternReg, err = regexp.Compile(o.filter); err != nil {
+			return err
+		}
+	}
+	if len(o.exclude) > 0 {
+		if excludePatternReg, err = regexp.Compile(o.exclude); err != nil {
+			return err
+		}
+	}
+	cli, err := o.commonOptions.complete()
+	if err != nil {
+		return err
+	}
+	indices, err := cli.catIndices("")
+	if err != nil {
+		return fmt.Errorf("fetch indices: %s", err)
+	}
+	rows := make([]*indexRow, 0, len(indices))
+	for _, index := range indices {
+		if o.skipDotPrefix && strings.HasPrefix(index.Index, ".") {
+			continue
+		}
+		if filterPatternReg != nil && !filterPatternReg.MatchString(index.Index) {
+			continue
+		}
+		if excludePatternReg != nil && excludePatternReg.MatchString(index.Index) {
+			continue
+		}
+		rows = append(rows, &indexRow{
+			Index:  index.Index,
+			Health: index.Health,
+			Status: index.Status,
+			Docs:   index.docs(),
+			Size:   index.size(),
+		})
+	}
+	if o.groupByPattern {
+		groups := groupByPattern(rows)
+		sort.Slice(groups, func(i, j int) bool {
+			return less(o.sortBy, groups[i].Pattern, groups[j].Pattern, groups[i].Docs, groups[j].Docs, groups[i].Size, groups[j].Size)
+		})
+		return o.print(out, groups, func(tw io.Writer) {
+			fmt.Fprintln(tw, "PATTERN\tINDICES\tDOCS\tSIZE\tFROM\tTO")
+			for _, g := range groups {
+				fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n", g.Pattern, g.Indices, g.Docs, formatBytes(g.Size), g.From, g.To)
+			}
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return less(o.sortBy, rows[i].Index, rows[j].Index, rows[i].Docs, rows[j].Docs, rows[i].Size, rows[j].Size)
+	})
+	return o.print(out, rows, func(tw io.Writer) {
+		fmt.Fprintln(tw, "INDEX\tHEALTH\tSTATUS\tDOCS\tSIZE")
+		for _, r := range rows {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.Index, r.Health, r.Status, r.Docs, formatBytes(r.Size))
+		}
+	})
+}
+
+func less(sortBy, name1, name2 string, docs1, docs2, size1, size2 int64) bool {
+	switch {
+	case sortBy == "docs" && docs1 != docs2:
+		return docs1 > docs2
+	case sortBy == "size" && size1 != size2:
+		return size1 > size2
+	}
+	return name1 < name2
+}
+
+func groupByPattern(rows []*indexRow) []*patternRow {
+	groups := make(map[string]*patternRow)
+	for _, r := range rows {
+		pattern, date, ok := parseIndexDate(r.Index)
+		if !ok {
+			pattern = r.Index
+		}
+		g, exists := groups[pattern]
+		if !exists {
+			g = &patternRow{Pattern: pattern}
+			groups[pattern] = g
+		}
+		g.Indices++
+		g.Docs += r.Docs
+		g.Size += r.Size
+		if ok {
+			// dates in format of YYYY.MM.DD can be compared as strings
+			d := date.Format("2006.01.02")
+			if g.From == "" || d < g.From {
+				g.From = d
+			}
+			if d > g.To {
+				g.To = d
+			}
+		}
+	}
+	ret := make([]*patternRow, 0, len(groups))
+	for _, g := range groups {
+		ret = append(ret, g)
+	}
+	return ret
+}
+
+// print writes v in json or yaml, or table written by printTable
+func (o *listOptions) print(out io.Writer, v interface{}, printTable func(tw io.Writer)) error {
+	switch o.output {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		enc := yaml.NewEncoder(out)
+		defer enc.Close()
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	printTable(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,389 @@
+package es
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+func newPatternsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "patterns",
+		Aliases: []string{"pattern"},
+		Short:   "Manage kibana index patterns",
+	}
+	cmd.AddCommand(newApplyPatternsCommand())
+	cmd.AddCommand(newGCPatternsCommand())
+	return cmd
+}
+
+// indexPattern is the index pattern in index pattern API of kibana
+type indexPattern struct {
+	ID            string `json:"id,omitempty" yaml:"id,omitempty"`
+	Title         string `json:"title" yaml:"title"`
+	TimeFieldName string `json:"timeFieldName,omitempty" yaml:"timeFieldName,omitempty"`
+	// FieldFormats are formats by field name, eg. {"bytes": {"id": "bytes"}}
+	FieldFormats map[string]interface{} `json:"fieldFormats,omitempty" yaml:"fieldFormats,omitempty"`
+}
+
+// savedIndexPattern is index pattern saved object returned by find API
+type savedIndexPattern struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Title          string `json:"title"`
+		TimeFieldName  string `json:"timeFieldName"`
+		FieldFormatMap string `json:"fieldFormatMap"`
+	} `json:"attributes"`
+}
+
+func (p *savedIndexPattern) fieldFormats() map[string]interface{} {
+	var m map[string]interface{}
+	json.Unmarshal([]byte(p.Attributes.FieldFormatMap), &m)
+	return m
+}
+
+// findIndexPatterns lists all index patterns in namespace
+func (c *client) findIndexPatterns(namespace string) ([]*savedIndexPattern, error) {
+	const perPage = 1000
+	var patterns []*savedIndexPattern
+	for page := 1; ; page++ {
+		uri := *c.kibanaURL
+		uri.Path = fmt.Sprintf("/s/%s/api/saved_objects/_find", namespace)
+		uri.RawQuery = url.Values{
+			"type":     []string{"index-pattern"},
+			"fields":   []string{"title", "timeFieldName", "fieldFormatMap"},
+			"per_page": []string{fmt.Sprint(perPage)},
+			"page":     []string{fmt.Sprint(page)},
+		}.Encode()
+		body, err := c.doRequest(http.MethodGet, uri.String(), nil, false)
+		if err != nil {
+			return nil, err
+		}
+		var ret struct {
+			Total        int                  `json:"total"`
+			SavedObjects []*savedIndexPattern `json:"saved_objects"`
+		}
+		if err = json.Unmarshal(body, &ret); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ret.SavedObjects...)
+		if len(ret.SavedObjects) < perPage || len(patterns) >= ret.Total {
+			return patterns, nil
+		}
+	}
+}
+
+// createIndexPattern creates index pattern in namespace, or updates it if override is true
+func (c *client) createIndexPattern(namespace string, p *indexPattern, override, refresh bool) error {
+	uri := *c.kibanaURL
+	uri.Path = fmt.Sprintf("/s/%s/api/index_patterns/index_pattern", namespace)
+	b, err := json.Marshal(map[string]interface{}{
+		"override":       override,
+		"refresh_fields": refresh,
+		"index_pattern":  p,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.doRequest(http.MethodPost, uri.String(), bytes.NewReader(b), c.dryRun)
+	return err
+}
+
+// updateIndexPattern updates title, time field and field formats of index pattern by id
+func (c *client) updateIndexPattern(namespace, id string, p *indexPattern) error {
+	uri := *c.kibanaURL
+	uri.Path = fmt.Sprintf("/s/%s/api/index_patterns/index_pattern/%s", namespace, id)
+	fieldFormats := p.FieldFormats
+	if fieldFormats == nil {
+		fieldFormats = map[string]interface{}{}
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"index_pattern": map[string]interface{}{
+			"title":         p.Title,
+			"timeFieldName": p.TimeFieldName,
+			"fieldFormats":  fieldFormats,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.doRequest(http.MethodPost, uri.String(), bytes.NewReader(b), c.dryRun)
+	return err
+}
+
+// patternsFile declares index patterns by kibana namespace, eg.
+//
+//	spaces:
+//	  default:
+//	  - title: app-*
+//	    timeFieldName: "@timestamp"
+//	    fieldFormats:
+//	      bytes:
+//	        id: bytes
+//	  ops:
+//	  - title: nginx-*
+//	    id: nginx
+type patternsFile struct {
+	Spaces map[string][]*indexPattern `yaml:"spaces"`
+}
+
+func loadPatternsFile(fn string) (*patternsFile, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	f := &patternsFile{}
+	if err = yaml.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	for space, patterns := range f.Spaces {
+		titles := make(map[string]bool)
+		for _, p := range patterns {
+			if p == nil || p.Title == "" {
+				return nil, fmt.Errorf("space %s: title of index pattern is required", space)
+			}
+			if titles[p.Title] {
+				return nil, fmt.Errorf("space %s: duplicated index pattern %s", space, p.Title)
+			}
+			titles[p.Title] = true
+			// normalize values decoded from yaml so that they are comparable with json
+			if p.FieldFormats != nil {
+				b, err := json.Marshal(p.FieldFormats)
+				if err != nil {
+					return nil, fmt.Errorf("space %s: field formats of %s: %v", space, p.Title, err)
+				}
+				p.FieldFormats = nil
+				json.Unmarshal(b, &p.FieldFormats)
+			}
+		}
+	}
+	return f, nil
+}
+
+const (
+	changeCreate = "+"
+	changeUpdate = "~"
+	changeDelete = "-"
+)
+
+// patternChange is a change in plan
+type patternChange struct {
+	action  string
+	space   string
+	id      string
+	desired *indexPattern
+	title   string
+	diff    []string
+}
+
+type applyPatternsOptions struct {
+	*commonOptions
+	file        string
+	prune       bool
+	refresh     bool
+	autoApprove bool
+	in          io.Reader
+}
+
+func newApplyPatternsCommand() *cobra.Command {
+	o := &applyPatternsOptions{
+		commonOptions: &commonOptions{},
+		in:            os.Stdin,
+	}
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Reconcile index patterns of kibana spaces with declarations, the plan is printed and confirmed before applying",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run(os.Stdout)
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("kibana-url")
+	cmd.Flags().StringVarP(&o.file, "file", "f", "", "File that declares index patterns by space")
+	cmd.MarkFlagRequired("file")
+	cmd.Flags().BoolVar(&o.prune, "prune", false, "Delete index patterns of declared spaces that are not declared")
+	cmd.Flags().BoolVar(&o.refresh, "refresh", false, "Reloads index pattern fields after the index pattern is created")
+	cmd.Flags().BoolVar(&o.autoApprove, "auto-approve", false, "Apply the plan without asking for confirmation")
+	return cmd
+}
+
+func (o *applyPatternsOptions) Run(out io.Writer) error {
+	f, err := loadPatternsFile(o.file)
+	if err != nil {
+		return err
+	}
+	cli, err := o.complete()
+	if err != nil {
+		return err
+	}
+	spaces := make([]string, 0, len(f.Spaces))
+	for space := range f.Spaces {
+		spaces = append(spaces, space)
+	}
+	sort.Strings(spaces)
+
+	var changes []*patternChange
+	var unmanaged int
+	for _, space := range spaces {
+		existing, err := cli.findIndexPatterns(space)
+		if err != nil {
+			return fmt.Errorf("find index patterns of space %s: %v", space, err)
+		}
+		c, n := planPatterns(space, f.Spaces[space], existing, o.prune)
+		changes = append(changes, c...)
+		unmanaged += n
+	}
+	printPlan(out, changes, unmanaged)
+	if len(changes) == 0 || o.dryRun {
+		return nil
+	}
+	if !o.autoApprove && !confirm(o.in, out) {
+		return fmt.Errorf("apply cancelled, use --auto-approve to apply without confirmation")
+	}
+
+	var errs []error
+	for _, c := range changes {
+		switch c.action {
+		case changeCreate:
+			err = cli.createIndexPattern(c.space, c.desired, false, o.refresh)
+		case changeUpdate:
+			err = cli.updateIndexPattern(c.space, c.id, c.desired)
+		case changeDelete:
+			err = cli.deletePattern(c.space, c.id)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s/%s: %v", c.action, c.space, c.title, err))
+			continue
+		}
+		log.GetLogger().Infof("%s index pattern %s in space %s", c.action, c.title, c.space)
+	}
+	if len(errs) > 0 {
+		return errors.MultiError(errs)
+	}
+	fmt.Fprintf(out, "Apply complete! %d change(s) applied.\n", len(changes))
+	return nil
+}
+
+// confirm asks for approval of the plan, only `yes` is accepted
+func confirm(in io.Reader, out io.Writer) bool {
+	fmt.Fprint(out, "\nDo you want to apply these changes? Only 'yes' will be accepted to approve.\n\nEnter a value: ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(answer) == "yes"
+}
+
+// planPatterns returns changes that make existing index patterns of space the same
+// as desired ones, patterns are matched by title, preferring the one of declared ID
+// if there are duplicates. Number of existing patterns that are not declared, or
+// duplicates of declared ones, is returned as well, they are deleted only if prune
+// is true.
+func planPatterns(space string, desired []*indexPattern, existing []*savedIndexPattern, prune bool) (changes []*patternChange, unmanaged int) {
+	wantIDs := make(map[string]string, len(desired))
+	for _, p := range desired {
+		wantIDs[p.Title] = p.ID
+	}
+	byTitle := make(map[string]*savedIndexPattern, len(existing))
+	for _, p := range existing {
+		title := p.Attributes.Title
+		if cur, ok := byTitle[title]; !ok || (p.ID == wantIDs[title] && cur.ID != p.ID) {
+			byTitle[title] = p
+		}
+	}
+	declared := make(map[string]bool, len(desired))
+	for _, p := range desired {
+		declared[p.Title] = true
+		cur, ok := byTitle[p.Title]
+		if !ok {
+			changes = append(changes, &patternChange{action: changeCreate, space: space, id: p.ID, desired: p, title: p.Title})
+			continue
+		}
+		var diff []string
+		if cur.Attributes.TimeFieldName != p.TimeFieldName {
+			diff = append(diff, fmt.Sprintf("timeFieldName: %q => %q", cur.Attributes.TimeFieldName, p.TimeFieldName))
+		}
+		if curFormats := cur.fieldFormats(); !(len(curFormats) == 0 && len(p.FieldFormats) == 0) && !reflect.DeepEqual(curFormats, p.FieldFormats) {
+			diff = append(diff, fmt.Sprintf("fieldFormats: %s => %s", jsonString(curFormats), jsonString(p.FieldFormats)))
+		}
+		if len(diff) > 0 {
+			changes = append(changes, &patternChange{action: changeUpdate, space: space, id: cur.ID, desired: p, title: p.Title, diff: diff})
+		}
+	}
+	for _, p := range existing {
+		if declared[p.Attributes.Title] && byTitle[p.Attributes.Title].ID == p.ID {
+			continue
+		}
+		if !prune {
+			unmanaged++
+			continue
+		}
+		changes = append(changes, &patternChange{action: changeDelete, space: space, id: p.ID, title: p.Attributes.Title})
+	}
+	return changes, unmanaged
+}
+
+func jsonString(v interface{}) string {
+	if v == nil {
+		return "{}"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func printPlan(out io.Writer, changes []*patternChange, unmanaged int) {
+	counts := make(map[string]int)
+	space := ""
+	for _, c := range changes {
+		if c.space != space {
+			space = c.space
+			fmt.Fprintf(out, "space %s:\n", space)
+		}
+		counts[c.action]++
+		switch c.action {
+		case changeCreate:
+			var attrs []string
+			if c.desired.ID != "" {
+				attrs = append(attrs, "id="+c.desired.ID)
+			}
+			if c.desired.TimeFieldName != "" {
+				attrs = append(attrs, "timeFieldName="+c.desired.TimeFieldName)
+			}
+			if len(c.desired.FieldFormats) > 0 {
+				attrs = append(attrs, "fieldFormats="+jsonString(c.desired.FieldFormats))
+			}
+			fmt.Fprintf(out, "  %s %s (%s)\n", c.action, c.title, strings.Join(attrs, ", "))
+		case changeUpdate:
+			fmt.Fprintf(out, "  %s %s (id=%s)\n", c.action, c.title, c.id)
+			for _, d := range c.diff {
+				fmt.Fprintf(out, "      %s\n", d)
+			}
+		case changeDelete:
+			fmt.Fprintf(out, "  %s %s (id=%s)\n", c.action, c.title, c.id)
+		}
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No changes. Index patterns are up-to-date.")
+	} else {
+		fmt.Fprintf(out, "\nPlan: %d to create, %d to update, %d to delete.\n", counts[changeCreate], counts[changeUpdate], counts[changeDelete])
+	}
+	if unmanaged > 0 {
+		fmt.Fprintf(out, "%d index pattern(s) not declared or duplicated are kept, use --prune to delete them.\n", unmanaged)
+	}
+}
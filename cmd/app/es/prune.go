@@ -0,0 +1,178 @@
+package es
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+const (
+	pruneActionDelete = "delete"
+	pruneActionClose  = "close"
+)
+
+type pruneOptions struct {
+	*commonOptions
+	olderThan     string
+	filter        string
+	exclude       string
+	action        string
+	skipDotPrefix bool
+}
+
+func newPruneIndicesCommand() *cobra.Command {
+	o := &pruneOptions{
+		commonOptions: &commonOptions{},
+	}
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete or close daily indices older than given age, which is parsed from `-YYYY.MM.DD` suffix",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			o.setDefaults()
+			return o.Run(os.Stdout)
+		},
+	}
+	o.AddFlags(cmd.Flags())
+	cmd.MarkFlagRequired("es-url")
+	cmd.Flags().StringVar(&o.olderThan, "older-than", "", "Prune indices older than this, eg. 30d, 2w or 72h")
+	cmd.MarkFlagRequired("older-than")
+	cmd.Flags().StringVarP(&o.filter, "filter", "f", "", "Regexp pattern to filter, usually used to match prefix")
+	cmd.Flags().StringVar(&o.exclude, "exclude", "", "Regexp pattern to exclude")
+	cmd.Flags().StringVar(&o.action, "action", pruneActionDelete, "Action for matched indices, one of delete and close")
+	cmd.Flags().BoolVar(&o.skipDotPrefix, "skip-dot-prefix", true, "Skip indices with `.` prefix")
+	cmd.Flags().MarkHidden("skip-dot-prefix")
+	return cmd
+}
+
+// pruneResult is a row of summary table
+type pruneResult struct {
+	index *indexInfo
+	date  time.Time
+	err   error
+}
+
+func (o *pruneOptions) Run(out io.Writer) error {
+	if o.action != pruneActionDelete && o.action != pruneActionClose {
+		return fmt.Errorf("unknown action: %s", o.action)
+	}
+	age, err := parseAge(o.olderThan)
+	if err != nil {
+		return err
+	}
+	if age <= 0 {
+		return fmt.Errorf("older-than must be positive")
+	}
+	var filterPatternReg, excludePatternReg *regexp.Regexp
+	if len(o.filter) > 0 {
+		if filterPatternReg, err = regexp.Compile(o.filter); err != nil {
+			return err
+		}
+	}
+	if len(o.exclude) > 0 {
+		if excludePatternReg, err = regexp.Compile(o.exclude); err != nil {
+			return err
+		}
+	}
+	cli, err := o.commonOptions.complete()
+	if err != nil {
+		return err
+	}
+	indices, err := cli.catIndices("")
+	if err != nil {
+		return fmt.Errorf("fetch indices: %s", err)
+	}
+	// indices are dated by day, so compare with the start of day
+	now := time.Now().UTC()
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(-age)
+
+	var results []*pruneResult
+	for _, index := range indices {
+		if o.skipDotPrefix && strings.HasPrefix(index.Index, ".") {
+			continue
+		}
+		if filterPatternReg != nil && !filterPatternReg.MatchString(index.Index) {
+			continue
+		}
+		if excludePatternReg != nil && excludePatternReg.MatchString(index.Index) {
+			continue
+		}
+		if o.action == pruneActionClose && index.Status == "close" {
+			continue
+		}
+		_, date, ok := parseIndexDate(index.Index)
+		if !ok || !date.Before(cutoff) {
+			continue
+		}
+		results = append(results, &pruneResult{index: index, date: date})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].index.Index < results[j].index.Index
+	})
+
+	var errs []error
+	for _, r := range results {
+		if r.err = cli.pruneIndex(o.action, r.index.Index); r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", r.index.Index, r.err))
+			continue
+		}
+		if !o.dryRun {
+			log.GetLogger().Infof("%s index %s", o.action, r.index.Index)
+		}
+	}
+	if err = o.printSummary(out, results, now); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errors.MultiError(errs)
+	}
+	return nil
+}
+
+func (o *pruneOptions) printSummary(out io.Writer, results []*pruneResult, now time.Time) error {
+	tw := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(tw, "INDEX\tDATE\tAGE\tSTATUS\tDOCS\tSIZE\tACTION\tRESULT")
+	var size int64
+	var failed int
+	for _, r := range results {
+		result := "ok"
+		switch {
+		case r.err != nil:
+			result = "failed"
+			failed++
+		case o.dryRun:
+			result = "dry-run"
+		}
+		size += r.index.size()
+		fmt.Fprintf(tw, "%s\t%s\t%dd\t%s\t%d\t%s\t%s\t%s\n", r.index.Index, r.date.Format("2006.01.02"),
+			int(now.Sub(r.date).Hours()/24), r.index.Status, r.index.docs(), formatBytes(r.index.size()), o.action, result)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "\n%d indices matched, %d failed, %s in total\n", len(results), failed, formatBytes(size))
+	return err
+}
+
+// pruneIndex deletes or closes index
+func (c *client) pruneIndex(action, index string) error {
+	uri := *c.esURL
+	method := http.MethodDelete
+	uri.Path = "/" + index
+	if action == pruneActionClose {
+		method = http.MethodPost
+		uri.Path += "/_close"
+	}
+	_, err := c.doRequest(method, uri.String(), nil, c.dryRun)
+	return err
+}
@@ -0,0 +1,139 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+const (
+	strategyDaily      = "daily"
+	strategyMonthly    = "monthly"
+	strategyRollover   = "rollover"
+	strategyDataStream = "datastream"
+
+	// dataStreamPatternIDPrefix prefixes IDs of index patterns of data streams
+	dataStreamPatternIDPrefix = "datastream-"
+)
+
+var (
+	// monthlyIndexReg matches indices with month suffix like `app-2021.09`
+	monthlyIndexReg = regexp.MustCompile(`^([a-zA-Z0-9_-]+)-\d{4}\.\d{2}$`)
+	// rolloverIndexReg matches indices created by rollover API like `app-000012`,
+	// the counter has exactly 6 digits so that dates like `app-20210901` are not matched
+	rolloverIndexReg = regexp.MustCompile(`^(.+)-\d{6}$`)
+	// compactDailyIndexReg matches indices with date suffix like `app-20210901`
+	compactDailyIndexReg = regexp.MustCompile(`^([a-zA-Z0-9_-]+)-(\d{4})(\d{2})(\d{2})$`)
+)
+
+// indexStrategy derives name of index pattern from name of index, index
+// pattern `<name>-*` is created for indices of the same name
+type indexStrategy struct {
+	name  string
+	parse func(index string) (name string, ok bool)
+}
+
+func regexpStrategy(name string, reg *regexp.Regexp) *indexStrategy {
+	return &indexStrategy{
+		name: name,
+		parse: func(index string) (string, bool) {
+			ret := reg.FindStringSubmatch(index)
+			if len(ret) < 2 || len(ret[1]) == 0 {
+				return "", false
+			}
+			return ret[1], true
+		},
+	}
+}
+
+var indexStrategies = map[string]*indexStrategy{
+	strategyDaily: {
+		name: strategyDaily,
+		parse: func(index string) (string, bool) {
+			if pattern, _, ok := parseIndexDate(index); ok {
+				return pattern, true
+			}
+			ret := compactDailyIndexReg.FindStringSubmatch(index)
+			if len(ret) != 5 || len(ret[1]) == 0 {
+				return "", false
+			}
+			if _, err := time.Parse("20060102", ret[2]+ret[3]+ret[4]); err != nil {
+				return "", false
+			}
+			return ret[1], true
+		},
+	},
+	strategyMonthly:  regexpStrategy(strategyMonthly, monthlyIndexReg),
+	strategyRollover: regexpStrategy(strategyRollover, rolloverIndexReg),
+}
+
+// parseStrategies returns strategies of names in order, and whether data streams
+// are included. Pattern regexp takes precedence over named strategies, its first
+// submatch is the name of index pattern.
+func parseStrategies(names []string, patternRegex string) (strategies []*indexStrategy, dataStream bool, err error) {
+	if len(patternRegex) > 0 {
+		reg, err := regexp.Compile(patternRegex)
+		if err != nil {
+			return nil, false, err
+		}
+		if reg.NumSubexp() < 1 {
+			return nil, false, fmt.Errorf("pattern regex must have a submatch for name of index pattern: %s", patternRegex)
+		}
+		strategies = append(strategies, regexpStrategy("regex", reg))
+	}
+	for _, name := range names {
+		if name == strategyDataStream {
+			dataStream = true
+			continue
+		}
+		s, ok := indexStrategies[name]
+		if !ok {
+			return nil, false, fmt.Errorf("unknown strategy %s, must be one of %s", name,
+				strings.Join([]string{strategyDaily, strategyMonthly, strategyRollover, strategyDataStream}, ", "))
+		}
+		strategies = append(strategies, s)
+	}
+	return strategies, dataStream, nil
+}
+
+type dataStream struct {
+	Name           string `json:"name"`
+	TimestampField struct {
+		Name string `json:"name"`
+	} `json:"timestamp_field"`
+}
+
+// listDataStreams lists data streams through `_data_stream` API, there's no data
+// stream if the API is unavailable, eg. elasticsearch before 7.9
+func (c *client) listDataStreams() ([]*dataStream, error) {
+	uri := *c.esURL
+	uri.Path = "/_data_stream"
+	uri.RawQuery = ""
+	status, body, err := c.doRequestWithStatus(http.MethodGet, uri.String(), "application/json", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case status == http.StatusNotFound || status == http.StatusBadRequest:
+		log.GetLogger().Debugw("data stream API is unavailable", "status", status)
+		return nil, nil
+	case status/100 >= 4:
+		return nil, fmt.Errorf("unexpected error: %s", string(body))
+	}
+	var ret struct {
+		DataStreams []*dataStream `json:"data_streams"`
+	}
+	if err = json.Unmarshal(body, &ret); err != nil {
+		return nil, err
+	}
+	sort.Slice(ret.DataStreams, func(i, j int) bool {
+		return ret.DataStreams[i].Name < ret.DataStreams[j].Name
+	})
+	return ret.DataStreams, nil
+}
@@ -0,0 +1,400 @@
+package feishu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// card is the result of adapting webhook payload of a source. It's sent as an
+// interactive card, unless message type is specified by route
+type card struct {
+	title string
+	color string
+	// labels of payload for routing, `source` is always set
+	labels map[string]string
+	// data is passed to template of adapter
+	data interface{}
+	// ignored is the reason of payload being ignored, eg. a pipeline is still running
+	ignored string
+}
+
+// adapter converts webhook payloads of a source into messages
+type adapter struct {
+	source string
+	parse  func(r *http.Request) (*card, error)
+	tmpl   *template.Template
+}
+
+var adapters = []*adapter{
+	{source: "grafana", parse: parseGrafana, tmpl: mustParseTemplate(grafanaTemplate)},
+	{source: "gitlab", parse: parseGitlab, tmpl: mustParseTemplate(gitlabTemplate)},
+	{source: "jenkins", parse: parseJenkins, tmpl: mustParseTemplate(jenkinsTemplate)},
+	{source: "argocd", parse: parseArgoCD, tmpl: mustParseTemplate(argoCDTemplate)},
+}
+
+func mustParseTemplate(text string) *template.Template {
+	return template.Must(template.New("").Funcs(templateFuncs).Parse(text))
+}
+
+// handleAdapter receives webhooks of the source of adapter, target bots are
+// specified by query parameter `bot`, otherwise message is routed by labels
+func (h *hub) handleAdapter(a *adapter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		c, err := a.parse(r)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode %s payload: %v", a.source, err))
+			return
+		}
+		if c.ignored != "" {
+			writeResponse(w, http.StatusOK, c.ignored)
+			return
+		}
+		buf := bytes.NewBuffer(nil)
+		if err = a.tmpl.Execute(buf, c.data); err != nil {
+			writeResponse(w, http.StatusInternalServerError, fmt.Sprintf("render message: %v", err))
+			return
+		}
+		if c.labels == nil {
+			c.labels = make(map[string]string)
+		}
+		c.labels["source"] = a.source
+		d := &delivery{
+			bots: r.URL.Query()["bot"],
+			spec: messageSpec{Title: c.title, Color: c.color},
+			body: strings.TrimSpace(buf.String()),
+		}
+		deliveries, err := h.route(d, c.labels, nil)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		for _, d := range deliveries {
+			if d.spec.MsgType == "" {
+				d.spec.MsgType = msgTypeInteractive
+			}
+		}
+		h.accept(w, r, deliveries...)
+	}
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// statusColor returns header color of card by status of build, pipeline or alert
+func statusColor(status string) string {
+	switch strings.ToLower(status) {
+	case "success", "succeeded", "ok", "resolved", "healthy", "synced", "merged":
+		return "green"
+	case "failed", "failure", "error", "firing", "alerting", "degraded":
+		return "red"
+	case "unstable", "pending", "no_data", "outofsync", "progressing", "missing":
+		return "orange"
+	case "canceled", "cancelled", "aborted", "skipped", "closed", "suspended":
+		return "grey"
+	}
+	return "blue"
+}
+
+// grafanaMessage is the payload of grafana alerting webhook contact point, fields of
+// legacy alerting are kept for grafana before 8.0
+// https://grafana.com/docs/grafana/latest/alerting/configure-notifications/manage-contact-points/integrations/webhook-notifier/
+type grafanaMessage struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	State             string            `json:"state"`
+	Title             string            `json:"title"`
+	Message           string            `json:"message"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []grafanaAlert    `json:"alerts"`
+	// legacy alerting
+	RuleName    string             `json:"ruleName"`
+	RuleURL     string             `json:"ruleUrl"`
+	EvalMatches []grafanaEvalMatch `json:"evalMatches"`
+}
+
+type grafanaAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	SilenceURL   string            `json:"silenceURL"`
+	DashboardURL string            `json:"dashboardURL"`
+	PanelURL     string            `json:"panelURL"`
+	ValueString  string            `json:"valueString"`
+}
+
+type grafanaEvalMatch struct {
+	Metric string            `json:"metric"`
+	Value  float64           `json:"value"`
+	Tags   map[string]string `json:"tags"`
+}
+
+const grafanaTemplate = `{{ if .Alerts -}}
+{{ range .Alerts -}}
+**[{{ .Status | upper }}] {{ or (index .Labels "alertname") "alert" }}**
+{{- with index .Annotations "summary" }}
+{{ . }}{{ end }}
+{{- with index .Annotations "description" }}
+{{ . }}{{ end }}
+{{- with .ValueString }}
+values: {{ . }}{{ end }}
+labels: {{ sortedPairs .Labels }}
+starts at: {{ timeFormat .StartsAt }}{{ if eq .Status "resolved" }}, ends at: {{ timeFormat .EndsAt }}{{ end }}
+{{- with .PanelURL }}
+[panel]({{ . }}){{ end }}{{ with .SilenceURL }} [silence]({{ . }}){{ end }}{{ with .GeneratorURL }} [source]({{ . }}){{ end }}
+{{ end -}}
+{{ else -}}
+{{ .Message }}
+{{ range .EvalMatches -}}
+- {{ .Metric }}: {{ .Value }}
+{{ end -}}
+{{ with .RuleURL }}[rule]({{ . }}){{ end }}
+{{ end -}}`
+
+func parseGrafana(r *http.Request) (*card, error) {
+	var m grafanaMessage
+	if err := decodeJSON(r, &m); err != nil {
+		return nil, err
+	}
+	status := m.Status
+	if status == "" {
+		status = m.State
+	}
+	labels := make(map[string]string, len(m.CommonLabels)+1)
+	for k, v := range m.CommonLabels {
+		labels[k] = v
+	}
+	labels["status"] = status
+	title := m.Title
+	if title == "" {
+		title = fmt.Sprintf("[%s] %s", strings.ToUpper(status), m.RuleName)
+	}
+	return &card{title: title, color: statusColor(status), labels: labels, data: &m}, nil
+}
+
+// gitlabMessage is the payload of gitlab pipeline and merge request events
+// https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html
+type gitlabMessage struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		ID           int    `json:"id"`
+		IID          int    `json:"iid"`
+		Ref          string `json:"ref"`
+		Status       string `json:"status"`
+		Source       string `json:"source"`
+		Duration     int    `json:"duration"`
+		URL          string `json:"url"`
+		Title        string `json:"title"`
+		State        string `json:"state"`
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+	} `json:"object_attributes"`
+	Commit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commit"`
+	Builds []struct {
+		Name   string `json:"name"`
+		Stage  string `json:"stage"`
+		Status string `json:"status"`
+	} `json:"builds"`
+}
+
+// PipelineURL returns url of pipeline, which is absent in payload of older gitlab
+func (m *gitlabMessage) PipelineURL() string {
+	if m.ObjectAttributes.URL != "" {
+		return m.ObjectAttributes.URL
+	}
+	return fmt.Sprintf("%s/-/pipelines/%d", m.Project.WebURL, m.ObjectAttributes.ID)
+}
+
+const gitlabTemplate = `{{ if eq .ObjectKind "pipeline" -}}
+**{{ .Project.PathWithNamespace }}** pipeline [#{{ .ObjectAttributes.ID }}]({{ .PipelineURL }}) **{{ .ObjectAttributes.Status }}**
+ref: {{ .ObjectAttributes.Ref }}, source: {{ .ObjectAttributes.Source }}{{ with .ObjectAttributes.Duration }}, duration: {{ . }}s{{ end }}
+commit: [{{ printf "%.8s" .Commit.ID }}]({{ .Commit.URL }}) {{ .Commit.Message | trim }} ({{ .Commit.Author.Name }})
+triggered by: {{ .User.Name }}
+{{- range .Builds }}{{ if eq .Status "failed" }}
+- failed job: {{ .Stage }}/{{ .Name }}{{ end }}{{ end }}
+{{- else -}}
+**{{ .Project.PathWithNamespace }}** merge request [!{{ .ObjectAttributes.IID }} {{ .ObjectAttributes.Title }}]({{ .ObjectAttributes.URL }}) **{{ .ObjectAttributes.Action }}**
+{{ .ObjectAttributes.SourceBranch }} → {{ .ObjectAttributes.TargetBranch }}, state: {{ .ObjectAttributes.State }}
+by: {{ .User.Name }}
+{{- end }}`
+
+// gitlabPendingStatuses are statuses of pipelines that are not finished, they're ignored
+var gitlabPendingStatuses = map[string]bool{
+	"created":              true,
+	"waiting_for_resource": true,
+	"preparing":            true,
+	"pending":              true,
+	"running":              true,
+	"scheduled":            true,
+}
+
+func parseGitlab(r *http.Request) (*card, error) {
+	var m gitlabMessage
+	if err := decodeJSON(r, &m); err != nil {
+		return nil, err
+	}
+	labels := map[string]string{
+		"kind":    m.ObjectKind,
+		"project": m.Project.PathWithNamespace,
+	}
+	attrs := m.ObjectAttributes
+	switch m.ObjectKind {
+	case "pipeline":
+		if gitlabPendingStatuses[attrs.Status] {
+			return &card{ignored: fmt.Sprintf("pipeline is %s", attrs.Status)}, nil
+		}
+		labels["ref"], labels["status"] = attrs.Ref, attrs.Status
+		title := fmt.Sprintf("%s pipeline %s", m.Project.Name, attrs.Status)
+		return &card{title: title, color: statusColor(attrs.Status), labels: labels, data: &m}, nil
+	case "merge_request":
+		labels["ref"], labels["status"] = attrs.TargetBranch, attrs.Action
+		title := fmt.Sprintf("%s merge request %s", m.Project.Name, attrs.Action)
+		return &card{title: title, color: statusColor(attrs.State), labels: labels, data: &m}, nil
+	}
+	return &card{ignored: fmt.Sprintf("unsupported gitlab event: %s", r.Header.Get("X-Gitlab-Event"))}, nil
+}
+
+// jenkinsMessage is the payload of jenkins notification plugin in JSON format
+// https://plugins.jenkins.io/notification/
+type jenkinsMessage struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	URL         string `json:"url"`
+	Build       struct {
+		FullURL    string            `json:"full_url"`
+		Number     int               `json:"number"`
+		Phase      string            `json:"phase"`
+		Status     string            `json:"status"`
+		Duration   int64             `json:"duration"`
+		Parameters map[string]string `json:"parameters"`
+		SCM        struct {
+			URL    string `json:"url"`
+			Branch string `json:"branch"`
+			Commit string `json:"commit"`
+		} `json:"scm"`
+	} `json:"build"`
+}
+
+const jenkinsTemplate = `**{{ .Name }}** build [#{{ .Build.Number }}]({{ .Build.FullURL }}) **{{ .Build.Status }}**
+{{- with .Build.SCM.Branch }}
+branch: {{ . }}{{ end }}{{ with .Build.SCM.Commit }}, commit: {{ printf "%.8s" . }}{{ end }}
+{{- with .Build.Duration }}
+duration: {{ . }}ms{{ end }}
+{{- with .Build.Parameters }}
+parameters: {{ sortedPairs . }}{{ end }}`
+
+func parseJenkins(r *http.Request) (*card, error) {
+	var m jenkinsMessage
+	if err := decodeJSON(r, &m); err != nil {
+		return nil, err
+	}
+	// notifications are sent on STARTED, COMPLETED and FINALIZED, only the last one is forwarded
+	if m.Build.Phase != "FINALIZED" {
+		return &card{ignored: fmt.Sprintf("build is %s", strings.ToLower(m.Build.Phase))}, nil
+	}
+	labels := map[string]string{
+		"job":    m.Name,
+		"status": strings.ToLower(m.Build.Status),
+		"branch": m.Build.SCM.Branch,
+	}
+	title := fmt.Sprintf("%s #%d %s", m.Name, m.Build.Number, m.Build.Status)
+	return &card{title: title, color: statusColor(m.Build.Status), labels: labels, data: &m}, nil
+}
+
+// argoCDMessage is the payload of argocd notifications webhook service, which is
+// defined by template of notification, eg.
+//
+//	template.app-sync-status: |
+//	  webhook:
+//	    feishu:
+//	      method: POST
+//	      body: |
+//	        {
+//	          "app": "{{.app.metadata.name}}",
+//	          "project": "{{.app.spec.project}}",
+//	          "event": "{{.app.status.operationState.phase}}",
+//	          "syncStatus": "{{.app.status.sync.status}}",
+//	          "healthStatus": "{{.app.status.health.status}}",
+//	          "revision": "{{.app.status.sync.revision}}",
+//	          "message": "{{.app.status.operationState.message}}",
+//	          "url": "{{.context.argocdUrl}}/applications/{{.app.metadata.name}}"
+//	        }
+type argoCDMessage struct {
+	App          string `json:"app"`
+	Project      string `json:"project"`
+	Event        string `json:"event"`
+	SyncStatus   string `json:"syncStatus"`
+	HealthStatus string `json:"healthStatus"`
+	Revision     string `json:"revision"`
+	Message      string `json:"message"`
+	URL          string `json:"url"`
+}
+
+const argoCDTemplate = `**{{ .App }}**{{ with .Project }} (project {{ . }}){{ end }}{{ with .Event }} **{{ . }}**{{ end }}
+sync: {{ default "-" .SyncStatus }}, health: {{ default "-" .HealthStatus }}
+{{- with .Revision }}
+revision: {{ printf "%.8s" . }}{{ end }}
+{{- with .Message }}
+{{ . }}{{ end }}
+{{- with .URL }}
+[view in argocd]({{ . }}){{ end }}`
+
+func parseArgoCD(r *http.Request) (*card, error) {
+	var m argoCDMessage
+	if err := decodeJSON(r, &m); err != nil {
+		return nil, err
+	}
+	if m.App == "" {
+		return nil, errors.New("app is required")
+	}
+	labels := map[string]string{
+		"app":           m.App,
+		"project":       m.Project,
+		"sync_status":   m.SyncStatus,
+		"health_status": m.HealthStatus,
+	}
+	status := m.Event
+	switch {
+	case strings.EqualFold(m.Event, "Error") || strings.EqualFold(m.Event, "Failed"):
+		status = "failed"
+	case m.HealthStatus != "" && m.HealthStatus != "Healthy":
+		status = m.HealthStatus
+	case m.Event == "" || strings.EqualFold(m.Event, "Succeeded"):
+		status = m.SyncStatus
+	}
+	title := fmt.Sprintf("%s %s", m.App, strings.TrimSpace(strings.Join([]string{m.Event, m.SyncStatus}, " ")))
+	return &card{title: title, color: statusColor(status), labels: labels, data: &m}, nil
+}
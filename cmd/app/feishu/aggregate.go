@@ -0,0 +1,201 @@
+package feishu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// deduper drops messages with the same key within window
+type deduper struct {
+	window time.Duration
+	mu     sync.Mutex
+	keys   map[string]time.Time
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window, keys: make(map[string]time.Time)}
+}
+
+// contentKey hashes spec, body and uploads of delivery, it's computed before
+// uploading so that duplicated messages cost no uploads
+func (d *delivery) contentKey() string {
+	h := sha256.New()
+	json.NewEncoder(h).Encode(d.spec)
+	h.Write([]byte(d.body))
+	if d.uploads != nil {
+		for _, attachments := range [][]*attachment{d.uploads.Images, d.uploads.Files} {
+			for _, a := range attachments {
+				h.Write([]byte(a.Name))
+				h.Write(a.Data)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// duplicated reports whether the key of bot has been seen within window,
+// the key is recorded if not
+func (d *deduper) duplicated(botName, key string, now time.Time) bool {
+	if d.seen(botName, key, now) {
+		return true
+	}
+	d.record(botName, key, now)
+	return false
+}
+
+// seen reports whether the key of bot has been recorded within window
+func (d *deduper) seen(botName, key string, now time.Time) bool {
+	if d == nil || d.window <= 0 {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, expiry := range d.keys {
+		if !expiry.After(now) {
+			delete(d.keys, k)
+		}
+	}
+	_, ok := d.keys[botName+"/"+key]
+	return ok
+}
+
+// record records the key of bot, it's seen within window since now
+func (d *deduper) record(botName, key string, now time.Time) {
+	if d == nil || d.window <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys[botName+"/"+key] = now.Add(d.window)
+}
+
+const (
+	maxAggregatedEntries = 20
+	// maxAggregatedSize caps text of a combined message, leaving room for escaping
+	// of JSON below the 20KB limit of feishu webhook requests
+	maxAggregatedSize = 8 * 1024
+
+	aggregateSeparator = "\n---\n"
+)
+
+func textOf(msg *message) (string, bool) {
+	if msg == nil || msg.MsgType != msgTypeText {
+		return "", false
+	}
+	var c struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(msg.Content, &c); err != nil {
+		return "", false
+	}
+	return c.Text, true
+}
+
+// aggregatable reports whether the message is a fresh text message
+// that could be combined with others
+func (e *envelope) aggregatable() bool {
+	if e.Count > 0 || e.Attempts > 0 || e.Receiver != nil {
+		return false
+	}
+	_, ok := textOf(e.Message)
+	return ok
+}
+
+// batches splits envelopes into batches to be combined into one message each, a
+// batch has at most maxAggregatedEntries entries and maxAggregatedSize bytes of
+// text unless it has only one entry
+func batches(envelopes []*envelope) [][]*envelope {
+	var ret [][]*envelope
+	var cur []*envelope
+	var size int
+	for _, e := range envelopes {
+		text, _ := textOf(e.Message)
+		n := len(text) + len(aggregateSeparator)
+		if len(cur) > 0 && (len(cur) == maxAggregatedEntries || size+n > maxAggregatedSize) {
+			ret = append(ret, cur)
+			cur, size = nil, 0
+		}
+		cur = append(cur, e)
+		size += n
+	}
+	if len(cur) > 0 {
+		ret = append(ret, cur)
+	}
+	return ret
+}
+
+// combine combines text messages into one with a count
+func combine(envelopes []*envelope) *envelope {
+	texts := make([]string, 0, len(envelopes))
+	for _, e := range envelopes {
+		text, _ := textOf(e.Message)
+		texts = append(texts, text)
+	}
+	body := fmt.Sprintf("%d messages aggregated\n\n%s", len(envelopes), strings.Join(texts, aggregateSeparator))
+	return &envelope{
+		Bot:       envelopes[0].Bot,
+		Message:   newTextMessage(body),
+		Count:     len(envelopes),
+		CreatedAt: envelopes[0].CreatedAt,
+	}
+}
+
+// merge replaces envelopes in queue with the combined one
+func (s *store) merge(envelopes []*envelope, combined *envelope) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		for _, e := range envelopes {
+			if err := b.Delete(itob(e.ID)); err != nil {
+				return err
+			}
+		}
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		combined.ID = id
+		return put(b, combined)
+	})
+}
+
+// aggregate holds fresh text messages of each bot within window since the first
+// one arrived, then combines them into as few messages as limits allow
+func (d *deliverer) aggregate(envelopes []*envelope, now time.Time) (ready []*envelope, err error) {
+	groups := make(map[string][]*envelope)
+	var order []string
+	for _, e := range envelopes {
+		if !e.aggregatable() {
+			ready = append(ready, e)
+			continue
+		}
+		if _, ok := groups[e.Bot]; !ok {
+			order = append(order, e.Bot)
+		}
+		groups[e.Bot] = append(groups[e.Bot], e)
+	}
+	for _, name := range order {
+		group := groups[name]
+		if group[0].CreatedAt.Add(d.aggregateWindow).After(now) {
+			continue
+		}
+		for _, batch := range batches(group) {
+			if len(batch) == 1 {
+				ready = append(ready, batch[0])
+				continue
+			}
+			combined := combine(batch)
+			if err = d.store.merge(batch, combined); err != nil {
+				return nil, err
+			}
+			ready = append(ready, combined)
+		}
+	}
+	return ready, nil
+}
@@ -0,0 +1,130 @@
+package feishu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// alertmanagerMessage is the payload of alertmanager webhook, version 4
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type alertmanagerMessage struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            alerts            `json:"alerts"`
+}
+
+type alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type alerts []alert
+
+// Firing returns the subset of alerts that are firing
+func (as alerts) Firing() alerts {
+	return as.filter("firing")
+}
+
+// Resolved returns the subset of alerts that are resolved
+func (as alerts) Resolved() alerts {
+	return as.filter("resolved")
+}
+
+func (as alerts) filter(status string) alerts {
+	ret := make(alerts, 0, len(as))
+	for _, a := range as {
+		if a.Status == status {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+const alertmanagerTemplate = `{{ if .Alerts.Firing -}}
+[FIRING:{{ len .Alerts.Firing }}] {{ sortedPairs .GroupLabels }}
+{{ range .Alerts.Firing -}}
+- {{ or (index .Annotations "summary") (index .Labels "alertname") }}
+{{- with index .Annotations "description" }}
+  {{ . }}{{ end }}
+  labels: {{ sortedPairs .Labels }}
+  starts at: {{ timeFormat .StartsAt }}
+{{ end -}}
+{{ end -}}
+{{ if .Alerts.Resolved -}}
+[RESOLVED:{{ len .Alerts.Resolved }}] {{ sortedPairs .GroupLabels }}
+{{ range .Alerts.Resolved -}}
+- {{ or (index .Annotations "summary") (index .Labels "alertname") }}
+  labels: {{ sortedPairs .Labels }}
+  starts at: {{ timeFormat .StartsAt }}, ends at: {{ timeFormat .EndsAt }}
+{{ end -}}
+{{ end -}}
+{{ if .TruncatedAlerts }}({{ .TruncatedAlerts }} alerts truncated)
+{{ end -}}`
+
+var defaultAlertmanagerTemplate = template.Must(template.New("alertmanager").Funcs(templateFuncs).Parse(alertmanagerTemplate))
+
+func (m *alertmanagerMessage) render() (string, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := defaultAlertmanagerTemplate.Execute(buf, m); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// handleAlertmanager receives notifications from alertmanager webhook_configs,
+// target bots are specified by query parameter `bot`, eg. /api/v1/alertmanager?bot=ops,
+// otherwise notification is routed by common labels of alerts
+func (h *hub) handleAlertmanager(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var m alertmanagerMessage
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode message: %v", err))
+		return
+	}
+	if m.Version != "" && m.Version != "4" {
+		writeResponse(w, http.StatusBadRequest, fmt.Sprintf("unsupported alertmanager webhook version: %s", m.Version))
+		return
+	}
+	if len(m.Alerts) == 0 {
+		writeResponse(w, http.StatusOK, "no alerts")
+		return
+	}
+	text, err := m.render()
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, fmt.Sprintf("render message: %v", err))
+		return
+	}
+	d := &delivery{bots: r.URL.Query()["bot"], body: text}
+	deliveries, err := h.route(d, m.CommonLabels, &m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	for _, d := range deliveries {
+		// rendered text is sent as text unless route specifies message type
+		if d.spec.MsgType == "" {
+			d.spec.MsgType = msgTypeText
+		}
+	}
+	h.accept(w, r, deliveries...)
+}
@@ -0,0 +1,82 @@
+package feishu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// attachment is an image or file to upload, data is base64 encoded in JSON
+type attachment struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// uploads are images and files attached to message. They're uploaded with
+// credentials of each bot, since keys are only valid for the app that uploaded them.
+type uploads struct {
+	// Images are embedded in image, post or interactive message
+	Images []*attachment `json:"images,omitempty"`
+	// Files are sent as file messages following the message
+	Files []*attachment `json:"files,omitempty"`
+}
+
+func (u *uploads) empty() bool {
+	return u == nil || len(u.Images)+len(u.Files) == 0
+}
+
+func readAttachments(fns []string) ([]*attachment, error) {
+	attachments := make([]*attachment, 0, len(fns))
+	for _, fn := range fns {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, &attachment{Name: filepath.Base(fn), Data: b})
+	}
+	return attachments, nil
+}
+
+// buildWithUploads uploads images and files with credentials of bot, then builds
+// message with images embedded, followed by file messages. Message is omitted if
+// body is empty and there's no image.
+func (b *bot) buildWithUploads(ctx context.Context, spec messageSpec, body string, u *uploads) ([]*message, error) {
+	if u.empty() {
+		m, err := b.build(spec, body)
+		if err != nil {
+			return nil, err
+		}
+		return []*message{m}, nil
+	}
+	if !b.IsApp() {
+		return nil, errors.New("uploading requires appID and appSecret of bot")
+	}
+	if len(u.Files) > 0 && !b.SendsAsApp() {
+		return nil, errors.New("files can only be sent as app with receiver")
+	}
+	for _, img := range u.Images {
+		key, err := b.UploadImage(ctx, img.Name, img.Data)
+		if err != nil {
+			return nil, fmt.Errorf("upload image %s: %w", img.Name, err)
+		}
+		spec.ImageKeys = append(spec.ImageKeys, key)
+	}
+	var msgs []*message
+	if len(body) > 0 || len(spec.ImageKeys) > 0 {
+		m, err := b.build(spec, body)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	for _, f := range u.Files {
+		key, err := b.UploadFile(ctx, f.Name, f.Data)
+		if err != nil {
+			return nil, fmt.Errorf("upload file %s: %w", f.Name, err)
+		}
+		msgs = append(msgs, newFileMessage(key))
+	}
+	return msgs, nil
+}
@@ -0,0 +1,212 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	headerClient    = "X-Hub-Client"
+	headerTimestamp = "X-Hub-Timestamp"
+	headerSignature = "X-Hub-Signature"
+
+	// maxClockSkew is the max difference between timestamp of signed request and now
+	maxClockSkew = 5 * time.Minute
+)
+
+// client of hub API, it authenticates with either bearer token or HMAC signature
+type client struct {
+	name string
+	// Token authenticates requests with header `Authorization: Bearer <token>`,
+	// or `X-Gitlab-Token: <token>` for gitlab webhooks
+	Token string `yaml:"token,omitempty"`
+	// Secret authenticates requests with headers X-Hub-Client, X-Hub-Timestamp and
+	// X-Hub-Signature, which is `sha256=` followed by hex encoded HMAC-SHA256 of
+	// timestamp, a newline and the request body
+	Secret string `yaml:"secret,omitempty"`
+	// Bots are names of bots the client may post to, `*` for all bots
+	Bots []string `yaml:"bots,omitempty"`
+	// Admin allows client to manage dead letters and silences
+	Admin bool `yaml:"admin,omitempty"`
+	// Quota limits messages posted by client, messages to multiple bots count per bot
+	Quota *quota `yaml:"quota,omitempty"`
+
+	limiter *rate.Limiter
+}
+
+type quota struct {
+	Messages int           `yaml:"messages"`
+	Per      time.Duration `yaml:"per"`
+}
+
+func (q *quota) equal(o *quota) bool {
+	if q == nil || o == nil {
+		return q == o
+	}
+	return *q == *o
+}
+
+func (c *client) validate() error {
+	if c.Token == "" && c.Secret == "" {
+		return errors.New("token or secret is required")
+	}
+	if c.Quota != nil && (c.Quota.Messages <= 0 || c.Quota.Per <= 0) {
+		return errors.New("messages and per of quota must be positive")
+	}
+	return nil
+}
+
+// allowed reports whether client may post to bot
+func (c *client) allowed(name string) bool {
+	for _, b := range c.Bots {
+		if b == "*" || b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setupLimiters creates limiters for quotas of clients, limiters of clients whose
+// quota is unchanged are taken over from old clients so that reloading config
+// does not reset quotas
+func setupLimiters(clients, old map[string]*client) {
+	for name, c := range clients {
+		if c.Quota == nil {
+			continue
+		}
+		if oc, ok := old[name]; ok && oc.Quota.equal(c.Quota) {
+			c.limiter = oc.limiter
+			continue
+		}
+		c.limiter = rate.NewLimiter(rate.Limit(float64(c.Quota.Messages)/c.Quota.Per.Seconds()), c.Quota.Messages)
+	}
+}
+
+type clientContextKey struct{}
+
+// clientFrom returns authenticated client of request, nil if authentication is disabled
+func clientFrom(r *http.Request) *client {
+	c, _ := r.Context().Value(clientContextKey{}).(*client)
+	return c
+}
+
+// authenticate returns handler that rejects requests of unknown clients, or of
+// clients that are not admin if admin is true. Authentication is disabled when
+// no client is configured, except for admin API which is always closed then.
+func (h *hub) authenticate(admin bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients := h.getClients()
+		if len(clients) == 0 {
+			if admin {
+				writeResponse(w, http.StatusForbidden, "admin API requires clients to be configured")
+				return
+			}
+			next(w, r)
+			return
+		}
+		c, err := authenticateRequest(r, clients, time.Now())
+		if err != nil {
+			writeResponse(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if admin && !c.Admin {
+			writeResponse(w, http.StatusForbidden, fmt.Sprintf("client %s is not admin", c.name))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), clientContextKey{}, c)))
+	}
+}
+
+func authenticateRequest(r *http.Request, clients map[string]*client, now time.Time) (*client, error) {
+	token := r.Header.Get("X-Gitlab-Token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token = strings.TrimPrefix(auth, "Bearer ")
+		if token == auth {
+			return nil, errors.New("unsupported authorization scheme")
+		}
+	}
+	if token != "" {
+		for _, c := range clients {
+			if c.Token != "" && subtle.ConstantTimeCompare([]byte(c.Token), []byte(token)) == 1 {
+				return c, nil
+			}
+		}
+		return nil, errors.New("invalid token")
+	}
+	name := r.Header.Get(headerClient)
+	if name == "" {
+		return nil, errors.New("missing credentials")
+	}
+	c, ok := clients[name]
+	if !ok || c.Secret == "" {
+		return nil, fmt.Errorf("unknown client: %s", name)
+	}
+	timestamp := r.Header.Get(headerTimestamp)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errors.New("timestamp is out of range")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	expected := "sha256=" + signRequest(c.Secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(headerSignature))) {
+		return nil, errors.New("signature mismatch")
+	}
+	return c, nil
+}
+
+// signRequest returns hex encoded HMAC-SHA256 of timestamp and body
+func signRequest(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// targets returns bots to post to for request, it fails if the bots are unknown,
+// not allowed for client or exceed quota of client. Bots allowed for client are
+// returned if names is empty.
+func (h *hub) targets(r *http.Request, names []string) ([]*bot, error) {
+	targets, err := h.lookup(names)
+	if err != nil {
+		return nil, &statusError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	c := clientFrom(r)
+	if c == nil {
+		return targets, nil
+	}
+	allowed := targets[:0]
+	for _, b := range targets {
+		if c.allowed(b.name) {
+			allowed = append(allowed, b)
+		} else if len(names) > 0 {
+			return nil, &statusError{status: http.StatusForbidden, msg: fmt.Sprintf("client %s is not allowed to post to bot %s", c.name, b.name)}
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, &statusError{status: http.StatusForbidden, msg: fmt.Sprintf("client %s is not allowed to post to any bot", c.name)}
+	}
+	if c.limiter != nil && !c.limiter.AllowN(time.Now(), len(allowed)) {
+		return nil, &statusError{status: http.StatusTooManyRequests, msg: fmt.Sprintf("quota of client %s exceeded", c.name)}
+	}
+	return allowed, nil
+}
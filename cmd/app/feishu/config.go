@@ -0,0 +1,163 @@
+package feishu
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+
+	"github.com/fengxsong/toolkit/cmd/app/options"
+	"github.com/fengxsong/toolkit/pkg/notify"
+)
+
+const defaultConfigFile = "~/.toolkit/feishu.yaml"
+
+type commonOptions struct {
+	configFile string
+}
+
+func (o *commonOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", defaultConfigFile, "Config file that defines named bot profiles")
+}
+
+// config of feishu commands, eg.
+//
+//	bots:
+//	  ops-alerts:
+//	    token: xxx
+//	    secret: yyy
+//	    msgType: post
+//	    at: [all]
+//	    # credentials of app for uploading images, messages are still sent through webhook
+//	    appID: cli_xxx
+//	    appSecret: yyy
+//	  oncall:
+//	    appID: cli_xxx
+//	    appSecret: yyy
+//	    receiveIDType: email
+//	    receiveID: someone@example.com
+//	events:
+//	  verificationToken: xxx
+//	  encryptKey: yyy
+//	  replyBot: ops-alerts
+//	  allowedChats: [oc_xxx]
+//	  allowedNamespaces: [default]
+//	clients:
+//	  grafana:
+//	    token: xxx
+//	    bots: [ops-alerts]
+//	    quota:
+//	      messages: 100
+//	      per: 1h
+//	  ci:
+//	    secret: yyy
+//	    bots: ["*"]
+//	    admin: true
+type config struct {
+	Bots   map[string]*bot `yaml:"bots"`
+	Events *eventsConfig   `yaml:"events,omitempty"`
+	// Clients of hub API, authentication is disabled if it's empty
+	Clients map[string]*client `yaml:"clients,omitempty"`
+	// Route is the root of routing tree of hub
+	Route *route `yaml:"route,omitempty"`
+}
+
+// loadConfig loads config from file, a missing default config file is not an error
+func (o *commonOptions) loadConfig() (*config, error) {
+	fn, err := options.ExpandHome(o.configFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{Bots: make(map[string]*bot)}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) && o.configFile == defaultConfigFile {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err = yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %v", fn, err)
+	}
+	for name, b := range cfg.Bots {
+		if b == nil || (b.Token == "" && b.AppID == "") {
+			return nil, fmt.Errorf("token or appID of bot %s is required", name)
+		}
+		b.name = name
+	}
+	for name, c := range cfg.Clients {
+		if c == nil {
+			return nil, fmt.Errorf("client %s is empty", name)
+		}
+		if err = c.validate(); err != nil {
+			return nil, fmt.Errorf("client %s: %v", name, err)
+		}
+		c.name = name
+	}
+	if cfg.Route != nil {
+		if err = cfg.Route.complete(nil); err != nil {
+			return nil, fmt.Errorf("route: %v", err)
+		}
+	}
+	return cfg, nil
+}
+
+// bot profile, it's either a custom bot with webhook token, or an app that
+// sends messages through open api
+type bot struct {
+	name          string
+	notify.Feishu `yaml:",inline"`
+
+	// default spec of messages sent by this bot
+	messageSpec `yaml:",inline"`
+}
+
+// withReceiver returns a copy of app bot that sends messages to the receiver
+func (b *bot) withReceiver(r *receiver) *bot {
+	if r == nil || !b.IsApp() {
+		return b
+	}
+	nb := *b
+	nb.ReceiveIDType, nb.ReceiveID = r.IDType, r.ID
+	return &nb
+}
+
+// parseBot parses bot in format name=token[:secret]
+func parseBot(s string) (*bot, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+		return nil, fmt.Errorf("invalid bot format: %s", s)
+	}
+	b := &bot{name: kv[0]}
+	credentials := strings.SplitN(kv[1], ":", 2)
+	b.Token = credentials[0]
+	if len(credentials) == 2 {
+		b.Secret = credentials[1]
+	}
+	return b, nil
+}
+
+func (b *bot) send(ctx context.Context, msg *message) error {
+	return b.Feishu.Send(ctx, msg)
+}
+
+// build builds message with spec, unset fields of spec fallback to defaults of bot
+func (b *bot) build(spec messageSpec, body string) (*message, error) {
+	if spec.MsgType == "" {
+		spec.MsgType = b.MsgType
+	}
+	if spec.Title == "" {
+		spec.Title = b.Title
+	}
+	if spec.Color == "" {
+		spec.Color = b.Color
+	}
+	if len(spec.At) == 0 {
+		spec.At = b.At
+	}
+	return spec.build(body)
+}
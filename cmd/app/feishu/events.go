@@ -0,0 +1,343 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fengxsong/toolkit/cmd/app/kube"
+	"github.com/fengxsong/toolkit/cmd/app/options"
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+// eventsConfig is the config of event subscription
+// https://open.feishu.cn/document/ukTMukTMukTM/uUTNz4SN1MjL1UzM
+type eventsConfig struct {
+	VerificationToken string `yaml:"verificationToken"`
+	EncryptKey        string `yaml:"encryptKey,omitempty"`
+	// ReplyBot is the name of bot to reply command results with
+	ReplyBot string `yaml:"replyBot,omitempty"`
+	// AllowedChats are ids of chats commands are accepted from, commands from
+	// any chat are accepted if empty
+	AllowedChats []string `yaml:"allowedChats,omitempty"`
+	// AllowedNamespaces are kubernetes namespaces commands may query, commands
+	// querying namespaces are refused if empty
+	AllowedNamespaces []string `yaml:"allowedNamespaces,omitempty"`
+}
+
+func (c *eventsConfig) chatAllowed(id string) bool {
+	return len(c.AllowedChats) == 0 || containsString(c.AllowedChats, id)
+}
+
+func (c *eventsConfig) namespaceAllowed(ns string) bool {
+	return containsString(c.AllowedNamespaces, ns)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// commandHandler handles bot command with arguments and returns the answer
+type commandHandler func(ctx context.Context, events *eventsConfig, args []string) (string, error)
+
+var commandHandlers = make(map[string]commandHandler)
+
+func registerCommand(name string, h commandHandler) {
+	if _, ok := commandHandlers[name]; ok {
+		panic("command already exists")
+	}
+	commandHandlers[name] = h
+}
+
+func init() {
+	registerCommand("help", func(_ context.Context, _ *eventsConfig, _ []string) (string, error) {
+		names := make([]string, 0, len(commandHandlers))
+		for name := range commandHandlers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("available commands: %s", strings.Join(names, ", ")), nil
+	})
+	registerCommand("deploys", func(ctx context.Context, events *eventsConfig, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", errors.New("usage: deploys <namespace>")
+		}
+		if !events.namespaceAllowed(args[0]) {
+			return "", fmt.Errorf("namespace %s is not allowed", args[0])
+		}
+		buf := bytes.NewBuffer(nil)
+		if err := kube.SummarizeDeployments(ctx, &options.KubeListOption{Namespace: args[0]}, buf); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	})
+}
+
+func decrypt(encrypted string, key string) ([]byte, error) {
+	buf, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < aes.BlockSize || len(buf)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	iv, buf := buf[:aes.BlockSize], buf[aes.BlockSize:]
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(buf, buf)
+	// pkcs7 unpadding
+	n := len(buf)
+	if n == 0 || int(buf[n-1]) > aes.BlockSize || int(buf[n-1]) > n {
+		return nil, errors.New("invalid padding")
+	}
+	return buf[:n-int(buf[n-1])], nil
+}
+
+// verifySignature verifies signature of request which is signed by encrypt key
+func verifySignature(r *http.Request, body []byte, encryptKey string) bool {
+	signature, err := hex.DecodeString(r.Header.Get("X-Lark-Signature"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	h := sha256.New()
+	h.Write([]byte(r.Header.Get("X-Lark-Request-Timestamp")))
+	h.Write([]byte(r.Header.Get("X-Lark-Request-Nonce")))
+	h.Write([]byte(encryptKey))
+	h.Write(body)
+	return hmac.Equal(h.Sum(nil), signature)
+}
+
+// callbackEvent is the union of url verification request and events in schema 2.0
+type callbackEvent struct {
+	Encrypt   string `json:"encrypt"`
+	Challenge string `json:"challenge"`
+	Token     string `json:"token"`
+	Type      string `json:"type"`
+	Schema    string `json:"schema"`
+	Header    struct {
+		EventID   string `json:"event_id"`
+		EventType string `json:"event_type"`
+		Token     string `json:"token"`
+	} `json:"header"`
+	Event json.RawMessage `json:"event"`
+}
+
+type messageReceiveEvent struct {
+	Sender struct {
+		SenderID struct {
+			OpenID string `json:"open_id"`
+			UserID string `json:"user_id"`
+		} `json:"sender_id"`
+	} `json:"sender"`
+	Message struct {
+		MessageID   string `json:"message_id"`
+		ChatID      string `json:"chat_id"`
+		ChatType    string `json:"chat_type"`
+		MessageType string `json:"message_type"`
+		Content     string `json:"content"`
+	} `json:"message"`
+}
+
+var mentionPlaceholderReg = regexp.MustCompile(`@_user_\d+`)
+
+// commandArgs extracts command and arguments from text message, mentions are removed
+func (e *messageReceiveEvent) commandArgs() []string {
+	if e.Message.MessageType != msgTypeText {
+		return nil
+	}
+	var c struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(e.Message.Content), &c); err != nil {
+		return nil
+	}
+	return strings.Fields(mentionPlaceholderReg.ReplaceAllString(c.Text, ""))
+}
+
+const (
+	defaultEventDedupWindow = 10 * time.Minute
+	// maxEventSize limits size of callback body, events are small json objects
+	maxEventSize = 1 << 20
+)
+
+// handleEvents handles callbacks of event subscription
+func (h *hub) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	events := h.getEvents()
+	if events == nil {
+		writeResponse(w, http.StatusNotFound, "event subscription is not configured")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxEventSize))
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var ev callbackEvent
+	if err = json.Unmarshal(body, &ev); err != nil {
+		writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode event: %v", err))
+		return
+	}
+	if len(ev.Encrypt) > 0 {
+		if events.EncryptKey == "" {
+			writeResponse(w, http.StatusBadRequest, "encrypt key is not configured")
+			return
+		}
+		plain, err := decrypt(ev.Encrypt, events.EncryptKey)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decrypt event: %v", err))
+			return
+		}
+		ev = callbackEvent{}
+		if err = json.Unmarshal(plain, &ev); err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode event: %v", err))
+			return
+		}
+	}
+	token := ev.Token
+	if ev.Schema != "" {
+		token = ev.Header.Token
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(events.VerificationToken)) != 1 {
+		writeResponse(w, http.StatusUnauthorized, "verification token mismatch")
+		return
+	}
+	// callbacks are signed if encrypt key is configured, except url verification
+	// which only echoes the challenge
+	if events.EncryptKey != "" && ev.Type != "url_verification" && !verifySignature(r, body, events.EncryptKey) {
+		writeResponse(w, http.StatusUnauthorized, "signature mismatch")
+		return
+	}
+	if ev.Type == "url_verification" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"challenge": ev.Challenge})
+		return
+	}
+	// events are redelivered if not responded in time
+	if ev.Header.EventID != "" && h.eventDeduper.duplicated("", ev.Header.EventID, time.Now()) {
+		writeResponse(w, http.StatusOK, "duplicated")
+		return
+	}
+	switch ev.Header.EventType {
+	case "im.message.receive_v1":
+		var e messageReceiveEvent
+		if err = json.Unmarshal(ev.Event, &e); err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode event: %v", err))
+			return
+		}
+		// respond as soon as possible, commands may take a while
+		go h.runCommand(&e)
+	default:
+		log.GetLogger().Debugw("ignore event", "type", ev.Header.EventType, "id", ev.Header.EventID)
+	}
+	writeResponse(w, http.StatusOK, "ok")
+}
+
+const (
+	defaultCommandTimeout = 30 * time.Second
+	// maxAnswerSize keeps answers well below size limit of feishu messages
+	maxAnswerSize = 4 * 1024
+)
+
+// truncateAnswer cuts answer longer than maxAnswerSize at the last line break
+func truncateAnswer(answer string) string {
+	if len(answer) <= maxAnswerSize {
+		return answer
+	}
+	cut := answer[:maxAnswerSize]
+	if i := strings.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i+1]
+	} else {
+		cut = strings.ToValidUTF8(cut, "")
+	}
+	return fmt.Sprintf("%s... truncated, %d of %d bytes shown", cut, len(cut), len(answer))
+}
+
+func (h *hub) runCommand(e *messageReceiveEvent) {
+	args := e.commandArgs()
+	if len(args) == 0 {
+		return
+	}
+	events := h.getEvents()
+	if events == nil {
+		return
+	}
+	if !events.chatAllowed(e.Message.ChatID) {
+		log.GetLogger().Infow("ignore command from chat not allowed", "command", args[0], "chat", e.Message.ChatID)
+		return
+	}
+	var answer string
+	handler, ok := commandHandlers[args[0]]
+	if !ok {
+		answer = fmt.Sprintf("unknown command: %s, try `help`", args[0])
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultCommandTimeout)
+		defer cancel()
+		out, err := handler(ctx, events, args[1:])
+		if err != nil {
+			answer = fmt.Sprintf("%s: %v", args[0], err)
+		} else {
+			answer = truncateAnswer(out)
+		}
+	}
+	if err := h.reply(e, answer); err != nil {
+		log.GetLogger().Errorw("failed to reply command", "command", args[0], "chat", e.Message.ChatID, "err", err)
+	}
+}
+
+// reply sends answer through the reply bot, mentioning the sender. If reply bot
+// is an app, answer is sent to the chat where the command comes from
+func (h *hub) reply(e *messageReceiveEvent, answer string) error {
+	events := h.getEvents()
+	if events == nil {
+		return errors.New("event subscription is not configured")
+	}
+	b, ok := h.getBot(events.ReplyBot)
+	if !ok {
+		return fmt.Errorf("unknown reply bot: %s", events.ReplyBot)
+	}
+	spec := messageSpec{MsgType: msgTypeText}
+	if id := e.Sender.SenderID.OpenID; id != "" {
+		spec.At = []string{id}
+	}
+	msg, err := spec.build(answer)
+	if err != nil {
+		return err
+	}
+	ev := &envelope{Bot: b.name, Message: msg, CreatedAt: time.Now()}
+	if b.IsApp() && e.Message.ChatID != "" {
+		ev.Receiver = &receiver{IDType: "chat_id", ID: e.Message.ChatID}
+	}
+	if err = h.store.enqueue(ev); err != nil {
+		return err
+	}
+	messagesReceived.WithLabelValues(b.name).Inc()
+	h.deliverer.wakeup()
+	return nil
+}
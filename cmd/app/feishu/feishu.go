@@ -17,7 +17,10 @@ func newSubCommand() *cobra.Command {
 		Use:   name,
 		Short: "feishu for dealing messages",
 	}
-	cmd.AddCommand(newSendCommand())
-	cmd.AddCommand(newServeCommand())
+	o := &commonOptions{}
+	o.AddFlags(cmd.PersistentFlags())
+	cmd.AddCommand(newSendCommand(o))
+	cmd.AddCommand(newServeCommand(o))
+	cmd.AddCommand(newSilenceCommand())
 	return cmd
 }
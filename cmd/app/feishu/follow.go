@@ -0,0 +1,140 @@
+package feishu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const maxLineSize = 1024 * 1024
+
+type followOptions struct {
+	enabled       bool
+	batchSize     int
+	batchInterval time.Duration
+	include       string
+	exclude       string
+
+	includeReg *regexp.Regexp
+	excludeReg *regexp.Regexp
+}
+
+func (o *followOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(&o.enabled, "follow", false, "continuously read lines from stdin and forward them in batches")
+	fs.IntVar(&o.batchSize, "batch-size", 20, "max number of lines in one message in follow mode")
+	fs.DurationVar(&o.batchInterval, "batch-interval", 5*time.Second, "max time to wait before forwarding pending lines in follow mode")
+	fs.StringVar(&o.include, "include", "", "regexp pattern, only lines matching it are forwarded in follow mode")
+	fs.StringVar(&o.exclude, "exclude", "", "regexp pattern, lines matching it are dropped in follow mode")
+}
+
+func (o *followOptions) complete() (err error) {
+	if o.batchSize <= 0 {
+		o.batchSize = 1
+	}
+	if o.batchInterval <= 0 {
+		return fmt.Errorf("batch interval must be positive, got %s", o.batchInterval)
+	}
+	if len(o.include) > 0 {
+		if o.includeReg, err = regexp.Compile(o.include); err != nil {
+			return err
+		}
+	}
+	if len(o.exclude) > 0 {
+		if o.excludeReg, err = regexp.Compile(o.exclude); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *followOptions) match(line string) bool {
+	if o.includeReg != nil && !o.includeReg.MatchString(line) {
+		return false
+	}
+	if o.excludeReg != nil && o.excludeReg.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// follow reads lines from r until EOF or ctx is done, matched lines are passed to
+// flush in batches when batch is full or batch interval is reached
+func (o *followOptions) follow(ctx context.Context, r io.Reader, flush func(lines []string)) error {
+	lineCh := make(chan string)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(lineCh)
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), maxLineSize)
+		for sc.Scan() {
+			select {
+			case lineCh <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		errCh <- sc.Err()
+	}()
+
+	var batch []string
+	doFlush := func() {
+		if len(batch) > 0 {
+			flush(batch)
+			batch = nil
+		}
+	}
+	ticker := time.NewTicker(o.batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-lineCh:
+			if !ok {
+				doFlush()
+				select {
+				case err := <-errCh:
+					return err
+				default:
+					return nil
+				}
+			}
+			if !o.match(line) {
+				continue
+			}
+			batch = append(batch, line)
+			if len(batch) >= o.batchSize {
+				doFlush()
+			}
+		case <-ticker.C:
+			doFlush()
+		case <-ctx.Done():
+			doFlush()
+			return nil
+		}
+	}
+}
+
+// readUntilBlankLine reads lines from r until a blank line or EOF
+func readUntilBlankLine(r io.Reader) (string, error) {
+	var lines []string
+	rd := bufio.NewReader(r)
+	for {
+		line, err := rd.ReadString('\n')
+		if len(line) > 0 && strings.TrimRight(line, "\r\n") == "" {
+			break
+		}
+		lines = append(lines, line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(lines, ""), nil
+}
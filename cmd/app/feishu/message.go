@@ -0,0 +1,215 @@
+package feishu
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fengxsong/toolkit/pkg/notify"
+)
+
+const (
+	msgTypeText        = notify.FeishuMsgTypeText
+	msgTypePost        = notify.FeishuMsgTypePost
+	msgTypeImage       = notify.FeishuMsgTypeImage
+	msgTypeInteractive = notify.FeishuMsgTypeInteractive
+	msgTypeFile        = notify.FeishuMsgTypeFile
+)
+
+// message is the body of feishu bot message
+type message = notify.FeishuMessage
+
+var newTextMessage = notify.NewFeishuTextMessage
+
+// messageSpec describes how to build a message from body
+type messageSpec struct {
+	MsgType string `json:"msg_type,omitempty" yaml:"msgType,omitempty"`
+	// Title of post, or header title of interactive card
+	Title string `json:"title,omitempty" yaml:"title,omitempty"`
+	// Color is the header template of interactive card, eg. red, orange, green, blue
+	Color string `json:"color,omitempty" yaml:"color,omitempty"`
+	// At is a list of open_id/user_id to mention, `all` for everyone
+	At []string `json:"at,omitempty" yaml:"at,omitempty"`
+	// ImageKeys are keys of uploaded images to embed in image, post or interactive message
+	ImageKeys []string `json:"image_keys,omitempty" yaml:"-"`
+}
+
+// build builds message with the given body. For non-text types, a JSON object body is
+// taken as the raw content(post, image) or card(interactive), otherwise the message is
+// constructed from plain text. Message with images defaults to image if it has only
+// one image and no body, otherwise post.
+func (s *messageSpec) build(body string) (*message, error) {
+	msgType := s.MsgType
+	if msgType == "" && len(s.ImageKeys) > 0 {
+		msgType = msgTypePost
+		if len(s.ImageKeys) == 1 && strings.TrimSpace(body) == "" {
+			msgType = msgTypeImage
+		}
+	}
+	if msgType == "" {
+		msgType = msgTypeText
+	}
+	raw := []byte(strings.TrimSpace(body))
+	isJSONObject := len(raw) > 0 && raw[0] == '{' && json.Valid(raw)
+	if isJSONObject && len(s.ImageKeys) > 0 {
+		return nil, errors.New("images can't be embedded in raw content")
+	}
+
+	switch msgType {
+	case msgTypeText:
+		if len(s.ImageKeys) > 0 {
+			return nil, errors.New("images can't be embedded in text message, use post instead")
+		}
+		return newTextMessage(body + s.textMentions()), nil
+	case msgTypePost:
+		if isJSONObject {
+			return &message{MsgType: msgType, Content: wrapContent("post", raw)}, nil
+		}
+		post := map[string]interface{}{
+			"zh_cn": map[string]interface{}{
+				"title":   s.Title,
+				"content": s.postParagraphs(body),
+			},
+		}
+		b, err := json.Marshal(map[string]interface{}{"post": post})
+		if err != nil {
+			return nil, err
+		}
+		return &message{MsgType: msgType, Content: b}, nil
+	case msgTypeImage:
+		if isJSONObject {
+			return &message{MsgType: msgType, Content: raw}, nil
+		}
+		imageKey := strings.TrimSpace(body)
+		if len(s.ImageKeys) > 0 {
+			if len(s.ImageKeys) > 1 || imageKey != "" {
+				return nil, errors.New("image message takes only one image, use post instead")
+			}
+			imageKey = s.ImageKeys[0]
+		}
+		b, err := json.Marshal(map[string]string{"image_key": imageKey})
+		if err != nil {
+			return nil, err
+		}
+		return &message{MsgType: msgType, Content: b}, nil
+	case msgTypeInteractive:
+		if isJSONObject {
+			return &message{MsgType: msgType, Card: raw}, nil
+		}
+		b, err := json.Marshal(s.card(body))
+		if err != nil {
+			return nil, err
+		}
+		return &message{MsgType: msgType, Card: b}, nil
+	}
+	return nil, fmt.Errorf("unsupported message type: %s", msgType)
+}
+
+// newFileMessage returns message of uploaded file, it can be sent only as app
+func newFileMessage(fileKey string) *message {
+	b, _ := json.Marshal(map[string]string{"file_key": fileKey})
+	return &message{MsgType: msgTypeFile, Content: b}
+}
+
+// wrapContent wraps raw under key if it's not wrapped yet
+func wrapContent(key string, raw []byte) json.RawMessage {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err == nil {
+		if _, ok := m[key]; ok {
+			return raw
+		}
+	}
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, `{%q:%s}`, key, raw)
+	return buf.Bytes()
+}
+
+func (s *messageSpec) textMentions() string {
+	var sb strings.Builder
+	for _, id := range s.At {
+		fmt.Fprintf(&sb, ` <at user_id="%s"></at>`, id)
+	}
+	return sb.String()
+}
+
+var markdownLinkReg = regexp.MustCompile(`\[([^\]]*)\]\(([^)\s]+)\)`)
+
+type postElement map[string]string
+
+// postParagraphs converts lines of text into paragraphs of post, markdown
+// links `[text](href)` are converted into link elements. Images are placed
+// in paragraphs after text.
+func (s *messageSpec) postParagraphs(body string) [][]postElement {
+	var lines []string
+	if body = strings.TrimRight(body, "\n"); body != "" || len(s.ImageKeys) == 0 {
+		lines = strings.Split(body, "\n")
+	}
+	paragraphs := make([][]postElement, 0, len(lines)+len(s.ImageKeys)+1)
+	for _, line := range lines {
+		var (
+			elements []postElement
+			last     int
+		)
+		for _, loc := range markdownLinkReg.FindAllStringSubmatchIndex(line, -1) {
+			if loc[0] > last {
+				elements = append(elements, postElement{"tag": "text", "text": line[last:loc[0]]})
+			}
+			elements = append(elements, postElement{"tag": "a", "text": line[loc[2]:loc[3]], "href": line[loc[4]:loc[5]]})
+			last = loc[1]
+		}
+		if last < len(line) || len(elements) == 0 {
+			elements = append(elements, postElement{"tag": "text", "text": line[last:]})
+		}
+		paragraphs = append(paragraphs, elements)
+	}
+	for _, key := range s.ImageKeys {
+		paragraphs = append(paragraphs, []postElement{{"tag": "img", "image_key": key}})
+	}
+	if len(s.At) > 0 {
+		elements := make([]postElement, 0, len(s.At))
+		for _, id := range s.At {
+			elements = append(elements, postElement{"tag": "at", "user_id": id})
+		}
+		paragraphs = append(paragraphs, elements)
+	}
+	return paragraphs
+}
+
+// card builds an interactive card with a colored header and a lark_md block
+func (s *messageSpec) card(body string) map[string]interface{} {
+	var sb strings.Builder
+	sb.WriteString(body)
+	for _, id := range s.At {
+		fmt.Fprintf(&sb, " <at id=%s></at>", id)
+	}
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag":  "div",
+			"text": map[string]string{"tag": "lark_md", "content": sb.String()},
+		},
+	}
+	for _, key := range s.ImageKeys {
+		elements = append(elements, map[string]interface{}{
+			"tag":     "img",
+			"img_key": key,
+			"alt":     map[string]string{"tag": "plain_text", "content": ""},
+		})
+	}
+	card := map[string]interface{}{
+		"config":   map[string]interface{}{"wide_screen_mode": true},
+		"elements": elements,
+	}
+	if s.Title != "" {
+		header := map[string]interface{}{
+			"title": map[string]string{"tag": "plain_text", "content": s.Title},
+		}
+		if s.Color != "" {
+			header["template"] = s.Color
+		}
+		card["header"] = header
+	}
+	return card
+}
@@ -0,0 +1,140 @@
+package feishu
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/fengxsong/toolkit/pkg/notify"
+)
+
+const metricsNamespace = "feishu_hub"
+
+var (
+	messagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Messages accepted into queue.",
+	}, []string{"bot"})
+	messagesDuplicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_duplicated_total",
+		Help:      "Messages dropped as duplicates.",
+	}, []string{"bot"})
+	messagesSilenced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_silenced_total",
+		Help:      "Messages suppressed by silences.",
+	}, []string{"bot"})
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "Messages delivered to feishu.",
+	}, []string{"bot"})
+	messagesRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_retried_total",
+		Help:      "Failed deliveries that are scheduled for retry.",
+	}, []string{"bot"})
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages moved to dead letters after exhausting attempts.",
+	}, []string{"bot"})
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful delivery.",
+	}, []string{"bot"})
+)
+
+// queueCollector collects depth of queue and dead letters on scrape
+type queueCollector struct {
+	store *store
+	desc  *prometheus.Desc
+}
+
+func newQueueCollector(s *store) *queueCollector {
+	return &queueCollector{
+		store: s,
+		desc: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "queue_depth"),
+			"Number of messages in queue or dead letters.", []string{"queue"}, nil),
+	}
+}
+
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, bucket := range map[string][]byte{"pending": queueBucket, "dead": deadBucket} {
+		n, err := c.store.depth(bucket)
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.desc, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(n), name)
+	}
+}
+
+// newRegistry returns registry with metrics of hub, requests to feishu and the process
+func (h *hub) newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		notify.RequestDuration,
+		messagesReceived,
+		messagesDuplicated,
+		messagesSilenced,
+		messagesSent,
+		messagesRetried,
+		messagesFailed,
+		lastSuccess,
+		newQueueCollector(h.store),
+	)
+	// expose zero values of known bots, so that absence of deliveries can be alerted on
+	bots, _ := h.lookup(nil)
+	for _, b := range bots {
+		for _, c := range []*prometheus.CounterVec{messagesReceived, messagesDuplicated, messagesSilenced, messagesSent, messagesRetried, messagesFailed} {
+			c.WithLabelValues(b.name)
+		}
+	}
+	return reg
+}
+
+func (h *hub) metricsHandler() http.Handler {
+	return promhttp.HandlerFor(h.newRegistry(), promhttp.HandlerOpts{})
+}
+
+// maxPollDelay is how long the deliverer may go without polling queue before
+// hub is considered not ready, waiting for busy workers counts as polling
+const maxPollDelay = time.Minute
+
+// handleHealthz reports the process is alive
+func (h *hub) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, http.StatusOK, "ok")
+}
+
+// handleReadyz reports whether store is accessible and deliverer is polling queue
+func (h *hub) handleReadyz(w http.ResponseWriter, _ *http.Request) {
+	if _, err := h.store.depth(queueBucket); err != nil {
+		writeResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("store: %v", err))
+		return
+	}
+	if last := h.deliverer.lastPolled(); time.Since(last) > maxPollDelay {
+		writeResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("deliverer has not polled queue since %s", last.Format(time.RFC3339)))
+		return
+	}
+	writeResponse(w, http.StatusOK, "ok")
+}
+
+// lastPolled returns the time deliverer polled queue last time
+func (d *deliverer) lastPolled() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&d.polledAt))
+}
@@ -0,0 +1,408 @@
+package feishu
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+var (
+	queueBucket = []byte("queue")
+	deadBucket  = []byte("dead")
+)
+
+// receiver overrides the receiver of app bot
+type receiver struct {
+	IDType string `json:"idType"`
+	ID     string `json:"id"`
+}
+
+// envelope wraps a message to deliver to a bot
+type envelope struct {
+	ID       uint64    `json:"id"`
+	Bot      string    `json:"bot"`
+	Receiver *receiver `json:"receiver,omitempty"`
+	Message  *message  `json:"message"`
+	Attempts int       `json:"attempts"`
+	// Count is the number of messages aggregated into this one
+	Count       int       `json:"count,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// store persists outbound messages and dead letters in a bolt database
+type store struct {
+	db *bolt.DB
+}
+
+func openStore(dataDir string) (*store, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dataDir, "hub.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open store: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{queueBucket, deadBucket, silenceBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &store{db: db}, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+func put(b *bolt.Bucket, e *envelope) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(e.ID), v)
+}
+
+func (s *store) enqueue(envelopes ...*envelope) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(queueBucket)
+		for _, e := range envelopes {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			e.ID = id
+			if err = put(b, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) list(bucket []byte, fn func(e *envelope) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			e := &envelope{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			if !fn(e) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// depth returns number of messages in bucket
+func (s *store) depth(bucket []byte) (n int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(bucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// ack removes delivered message from queue
+func (s *store) ack(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Delete(itob(id))
+	})
+}
+
+// requeue updates attempts of message in queue
+func (s *store) requeue(e *envelope) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(queueBucket), e)
+	})
+}
+
+// bury moves message from queue to dead letters
+func (s *store) bury(e *envelope) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(queueBucket).Delete(itob(e.ID)); err != nil {
+			return err
+		}
+		return put(tx.Bucket(deadBucket), e)
+	})
+}
+
+// replay moves dead letters back to queue, all dead letters if ids is empty
+func (s *store) replay(ids ...uint64) (n int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		dead, queue := tx.Bucket(deadBucket), tx.Bucket(queueBucket)
+		var keys [][]byte
+		if len(ids) == 0 {
+			dead.ForEach(func(k, _ []byte) error {
+				keys = append(keys, append([]byte(nil), k...))
+				return nil
+			})
+		}
+		for _, id := range ids {
+			keys = append(keys, itob(id))
+		}
+		for _, k := range keys {
+			v := dead.Get(k)
+			if v == nil {
+				return fmt.Errorf("dead letter %d not found", binary.BigEndian.Uint64(k))
+			}
+			e := &envelope{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			id, err := queue.NextSequence()
+			if err != nil {
+				return err
+			}
+			e.ID, e.Attempts, e.NextAttempt = id, 0, time.Time{}
+			if err = put(queue, e); err != nil {
+				return err
+			}
+			if err = dead.Delete(k); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// purge deletes dead letters, all dead letters if ids is empty
+func (s *store) purge(ids ...uint64) (n int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if len(ids) == 0 {
+			n = tx.Bucket(deadBucket).Stats().KeyN
+			if err := tx.DeleteBucket(deadBucket); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucket(deadBucket)
+			return err
+		}
+		dead := tx.Bucket(deadBucket)
+		for _, id := range ids {
+			if dead.Get(itob(id)) == nil {
+				return fmt.Errorf("dead letter %d not found", id)
+			}
+			if err := dead.Delete(itob(id)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+const (
+	defaultMaxAttempts   = 10
+	defaultPollInterval  = time.Second
+	defaultMaxRetryDelay = 5 * time.Minute
+)
+
+// deliverer drains the queue with workers
+type deliverer struct {
+	// polledAt is the unix nano time of last poll, accessed atomically
+	polledAt int64
+
+	store       *store
+	lookup      func(name string) (*bot, bool)
+	workers     int
+	maxAttempts int
+	// aggregateWindow is the window for combining text messages of the same bot, disabled if zero
+	aggregateWindow time.Duration
+
+	notify chan struct{}
+	mu     sync.Mutex
+	// inflight records messages being delivered and the time they are finished,
+	// so that stale snapshots of queue do not deliver a message twice.
+	inflight map[uint64]time.Time
+}
+
+func newDeliverer(s *store, lookup func(name string) (*bot, bool), workers, maxAttempts int) *deliverer {
+	if workers <= 0 {
+		workers = 1
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	return &deliverer{
+		store:       s,
+		lookup:      lookup,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		notify:      make(chan struct{}, 1),
+		inflight:    make(map[uint64]time.Time),
+	}
+}
+
+// wakeup notifies deliverer that there're new messages
+func (d *deliverer) wakeup() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers messages until ctx is done
+func (d *deliverer) Run(ctx context.Context) {
+	ch := make(chan *envelope)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < d.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range ch {
+				d.deliver(ctx, e)
+				d.mu.Lock()
+				d.inflight[e.ID] = time.Now()
+				d.mu.Unlock()
+			}
+		}()
+	}
+	ticker := time.NewTicker(defaultPollInterval)
+	defer func() {
+		ticker.Stop()
+		close(ch)
+		wg.Wait()
+	}()
+	for {
+		if err := d.poll(ctx, ch); err != nil {
+			log.GetLogger().Errorw("failed to poll queue", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.notify:
+		}
+	}
+}
+
+func (d *deliverer) poll(ctx context.Context, ch chan<- *envelope) error {
+	var ready []*envelope
+	now := time.Now()
+	err := d.store.list(queueBucket, func(e *envelope) bool {
+		d.mu.Lock()
+		_, ok := d.inflight[e.ID]
+		d.mu.Unlock()
+		if !ok && !e.NextAttempt.After(now) {
+			ready = append(ready, e)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	d.markPolled()
+	if d.aggregateWindow > 0 {
+		if ready, err = d.aggregate(ready, now); err != nil {
+			return err
+		}
+	}
+	// messages finished before this snapshot was taken are reflected in it
+	d.mu.Lock()
+	for id, finished := range d.inflight {
+		if !finished.IsZero() && finished.Before(now) {
+			delete(d.inflight, id)
+		}
+	}
+	d.mu.Unlock()
+	// dispatching blocks while all workers are busy, eg. waiting for rate limiters
+	// during alert storms, the deliverer is still alive then
+	heartbeat := time.NewTicker(defaultPollInterval)
+	defer heartbeat.Stop()
+	for _, e := range ready {
+		d.mu.Lock()
+		d.inflight[e.ID] = time.Time{}
+		d.mu.Unlock()
+	dispatch:
+		for {
+			select {
+			case ch <- e:
+				break dispatch
+			case <-heartbeat.C:
+				d.markPolled()
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (d *deliverer) markPolled() {
+	atomic.StoreInt64(&d.polledAt, time.Now().UnixNano())
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := time.Second << uint(attempts)
+	if delay <= 0 || delay > defaultMaxRetryDelay {
+		delay = defaultMaxRetryDelay
+	}
+	return delay
+}
+
+func (d *deliverer) deliver(ctx context.Context, e *envelope) {
+	var err error
+	b, ok := d.lookup(e.Bot)
+	if !ok {
+		err = fmt.Errorf("unknown bot: %s", e.Bot)
+		e.Attempts = d.maxAttempts
+	} else {
+		err = b.withReceiver(e.Receiver).send(ctx, e.Message)
+	}
+	if err != nil && ctx.Err() != nil {
+		// interrupted by shutting down, leave it in queue
+		return
+	}
+	if err == nil {
+		messagesSent.WithLabelValues(e.Bot).Inc()
+		lastSuccess.WithLabelValues(e.Bot).SetToCurrentTime()
+		if err = d.store.ack(e.ID); err != nil {
+			log.GetLogger().Errorw("failed to ack message", "id", e.ID, "err", err)
+		}
+		return
+	}
+	e.Attempts++
+	e.LastError = err.Error()
+	if e.Attempts >= d.maxAttempts {
+		messagesFailed.WithLabelValues(e.Bot).Inc()
+		log.GetLogger().Errorw("message moved to dead letters", "id", e.ID, "bot", e.Bot, "attempts", e.Attempts, "err", err)
+		err = d.store.bury(e)
+	} else {
+		e.NextAttempt = time.Now().Add(retryDelay(e.Attempts))
+		messagesRetried.WithLabelValues(e.Bot).Inc()
+		log.GetLogger().Warnw("failed to deliver message, will retry", "id", e.ID, "bot", e.Bot, "attempts", e.Attempts, "next", e.NextAttempt, "err", e.LastError)
+		err = d.store.requeue(e)
+	}
+	if err != nil {
+		log.GetLogger().Errorw("failed to update message", "id", e.ID, "err", err)
+	}
+}
@@ -0,0 +1,226 @@
+package feishu
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// route is a node of routing tree, messages are routed by their labels in the
+// spirit of alertmanager. A message goes down to the first matching child route,
+// or all matching child routes up to the first one that doesn't continue, and is
+// delivered by the deepest matching routes. Unset fields are inherited from parent.
+//
+//	route:
+//	  bots: [ops]
+//	  routes:
+//	  - matchers: [team=infra, severity=~"critical|warning"]
+//	    bots: [infra]
+//	    at: [ou_xxx]
+//	    msgType: interactive
+//	    title: infra alerts
+//	    template: |
+//	      [{{ .Labels.severity | upper }}] {{ .Text }}
+//	    continue: true
+type route struct {
+	// Matchers are in format of name=value, name!=value, name=~regexp or name!~regexp
+	Matchers []string `yaml:"matchers,omitempty"`
+	Bots     []string `yaml:"bots,omitempty"`
+	// Template renders text of message, data is routeData
+	Template string `yaml:"template,omitempty"`
+	// Continue goes on matching the following sibling routes after this one matched
+	Continue bool     `yaml:"continue,omitempty"`
+	Routes   []*route `yaml:"routes,omitempty"`
+	// spec of messages routed, mentions are added to mentions of messages
+	messageSpec `yaml:",inline"`
+
+	matchers []*matcher
+	tmpl     *template.Template
+}
+
+// routeData is the data of route template
+type routeData struct {
+	Labels map[string]string
+	// Text is text of message, or text rendered by default template for alerts
+	Text string
+	// Alerts is the notification of alertmanager, it's nil for other messages
+	Alerts *alertmanagerMessage
+}
+
+type matcher struct {
+	name  string
+	value string
+	equal bool
+	re    *regexp.Regexp
+}
+
+// parseMatcher parses matcher like `name=value`, `name!=value`, `name=~regexp` and
+// `name!~regexp`, value may be double quoted
+func parseMatcher(s string) (*matcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid matcher: %s", s)
+	}
+	m := &matcher{name: strings.TrimSpace(s[:i])}
+	op, rest, isRegexp := s[i:], "", false
+	switch {
+	case strings.HasPrefix(op, "=~"):
+		m.equal, rest, isRegexp = true, op[2:], true
+	case strings.HasPrefix(op, "!~"):
+		rest, isRegexp = op[2:], true
+	case strings.HasPrefix(op, "!="):
+		rest = op[2:]
+	case strings.HasPrefix(op, "="):
+		m.equal, rest = true, op[1:]
+	default:
+		return nil, fmt.Errorf("invalid matcher: %s", s)
+	}
+	m.value = strings.TrimSpace(rest)
+	if len(m.value) >= 2 && m.value[0] == '"' && m.value[len(m.value)-1] == '"' {
+		m.value = m.value[1 : len(m.value)-1]
+	}
+	if isRegexp {
+		re, err := regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %s: %v", s, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m *matcher) matches(labels map[string]string) bool {
+	v := labels[m.name]
+	if m.re != nil {
+		return m.re.MatchString(v) == m.equal
+	}
+	return (v == m.value) == m.equal
+}
+
+// complete parses matchers and template, and inherits unset fields from parent
+func (r *route) complete(parent *route) (err error) {
+	if parent != nil {
+		if len(r.Bots) == 0 {
+			r.Bots = parent.Bots
+		}
+		if r.Template == "" {
+			r.tmpl = parent.tmpl
+		}
+		if r.MsgType == "" {
+			r.MsgType = parent.MsgType
+		}
+		if r.Title == "" {
+			r.Title = parent.Title
+		}
+		if r.Color == "" {
+			r.Color = parent.Color
+		}
+		if len(r.At) == 0 {
+			r.At = parent.At
+		}
+	} else if len(r.Matchers) > 0 {
+		return errors.New("root route can't have matchers")
+	}
+	if r.Template != "" {
+		if r.tmpl, err = template.New("route").Funcs(templateFuncs).Parse(r.Template); err != nil {
+			return err
+		}
+	}
+	r.matchers = make([]*matcher, 0, len(r.Matchers))
+	for _, s := range r.Matchers {
+		m, err := parseMatcher(s)
+		if err != nil {
+			return err
+		}
+		r.matchers = append(r.matchers, m)
+	}
+	for _, c := range r.Routes {
+		if c == nil {
+			return errors.New("empty route")
+		}
+		if err = c.complete(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks that bots of routes exist
+func (r *route) validate(bots map[string]*bot) error {
+	if len(r.Bots) == 0 {
+		return errors.New("bots of route are required")
+	}
+	for _, name := range r.Bots {
+		if _, ok := bots[name]; !ok {
+			return fmt.Errorf("bot of route not found: %s", name)
+		}
+	}
+	for _, c := range r.Routes {
+		if err := c.validate(bots); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match returns the deepest routes matching labels, nil if r doesn't match
+func (r *route) match(labels map[string]string) []*route {
+	for _, m := range r.matchers {
+		if !m.matches(labels) {
+			return nil
+		}
+	}
+	var matched []*route
+	for _, c := range r.Routes {
+		m := c.match(labels)
+		matched = append(matched, m...)
+		if len(m) > 0 && !c.Continue {
+			break
+		}
+	}
+	if len(matched) == 0 {
+		return []*route{r}
+	}
+	return matched
+}
+
+// route returns deliveries of d routed by labels, d is returned as is if bots are
+// specified explicitly or routing is not configured. Text of message is rendered
+// by template of route if any, spec set by message takes precedence over route.
+func (h *hub) route(d *delivery, labels map[string]string, alerts *alertmanagerMessage) ([]*delivery, error) {
+	h.mu.RLock()
+	root := h.rootRoute
+	h.mu.RUnlock()
+	d.labels = labels
+	if root == nil || len(d.bots) > 0 {
+		return []*delivery{d}, nil
+	}
+	var deliveries []*delivery
+	for _, r := range root.match(labels) {
+		nd := *d
+		nd.bots = r.Bots
+		if nd.spec.MsgType == "" {
+			nd.spec.MsgType = r.MsgType
+		}
+		if nd.spec.Title == "" {
+			nd.spec.Title = r.Title
+		}
+		if nd.spec.Color == "" {
+			nd.spec.Color = r.Color
+		}
+		nd.spec.At = append(append([]string(nil), nd.spec.At...), r.At...)
+		if r.tmpl != nil {
+			buf := bytes.NewBuffer(nil)
+			if err := r.tmpl.Execute(buf, &routeData{Labels: labels, Text: d.body, Alerts: alerts}); err != nil {
+				return nil, &statusError{status: http.StatusInternalServerError, msg: fmt.Sprintf("render template of route: %v", err)}
+			}
+			nd.body = strings.TrimSpace(buf.String())
+		}
+		deliveries = append(deliveries, &nd)
+	}
+	return deliveries, nil
+}
@@ -1,126 +1,181 @@
 package feishu
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/cmd/app/options"
+	"github.com/fengxsong/toolkit/pkg/log"
 )
 
-type options struct {
+type sendOptions struct {
+	*commonOptions
+	bot   string
 	token string
 	sign  string
-	msg   string
-	file  string
+	// credentials of app and receiver
+	appID         string
+	appSecret     string
+	receiveIDType string
+	receiveID     string
+	msg           string
+	file          string
+	// template file and data for rendering message
+	template string
+	data     string
+	// images to embed and files to send following the message
+	images      []string
+	attachments []string
+	follow      followOptions
+	messageSpec
 }
 
-func newSendCommand() *cobra.Command {
-	o := &options{}
+func newSendCommand(co *commonOptions) *cobra.Command {
+	o := &sendOptions{commonOptions: co}
 	cmd := &cobra.Command{
 		Use:   "send",
-		Short: "For sending simple message to feishu webhook",
+		Short: "For sending message to feishu webhook, or chat/user as app",
 		RunE: func(_ *cobra.Command, _ []string) error {
-			if len(o.file) > 0 {
+			b, err := o.resolveBot()
+			if err != nil {
+				return err
+			}
+			if o.follow.enabled {
+				return o.runFollow(b)
+			}
+			if len(o.template) > 0 {
+				data, err := loadTemplateData(o.data, os.Stdin)
+				if err != nil {
+					return err
+				}
+				if o.msg, err = renderTemplateFile(o.template, data); err != nil {
+					return err
+				}
+			} else if len(o.file) > 0 {
 				b, err := ioutil.ReadFile(o.file)
 				if err != nil {
 					return err
 				}
 				o.msg = string(b)
 			} else if o.msg == "-" {
-				var lines []string
-				for {
-					rd := bufio.NewReader(os.Stdin)
-					buf, err := rd.ReadString('\n')
-					if err != nil {
-						return err
-					}
-					if buf[0] == '\n' {
-						break
-					}
-					lines = append(lines, string(buf))
+				if o.msg, err = readUntilBlankLine(os.Stdin); err != nil {
+					return err
 				}
-				o.msg = strings.Join(lines, "")
 			}
-			if len(o.msg) == 0 {
+			if len(o.msg) == 0 && len(o.images) == 0 && len(o.attachments) == 0 {
 				return nil
 			}
-			return send(o.token, o.sign, strings.ReplaceAll(o.msg, "\\n", "\n"))
+			if len(o.template) == 0 {
+				o.msg = strings.ReplaceAll(o.msg, "\\n", "\n")
+			}
+			u := &uploads{}
+			if u.Images, err = readAttachments(o.images); err != nil {
+				return err
+			}
+			if u.Files, err = readAttachments(o.attachments); err != nil {
+				return err
+			}
+			ctx := context.Background()
+			msgs, err := b.buildWithUploads(ctx, o.messageSpec, o.msg, u)
+			if err != nil {
+				return err
+			}
+			for _, m := range msgs {
+				if err = b.send(ctx, m); err != nil {
+					return err
+				}
+			}
+			return nil
 		},
 	}
-	cmd.Flags().StringVar(&o.token, "token", "", "feishu webhook token")
+	cmd.Flags().StringVar(&o.bot, "bot", "", "name of bot profile defined in config")
+	cmd.Flags().StringVar(&o.token, "token", "", "feishu webhook token, overrides token of bot profile")
 	cmd.Flags().StringVar(&o.sign, "sign", "", "feishu webhook signature")
+	cmd.Flags().StringVar(&o.appID, "app-id", "", "feishu app id, for sending message through open api instead of webhook, default $FEISHU_APP_ID if no bot profile is selected")
+	cmd.Flags().StringVar(&o.appSecret, "app-secret", "", "feishu app secret, default $FEISHU_APP_SECRET")
+	cmd.Flags().StringVar(&o.receiveIDType, "receive-id-type", "", "type of receive id, one of chat_id(default), open_id, user_id, union_id, email")
+	cmd.Flags().StringVar(&o.receiveID, "receive-id", "", "id of chat or user to send message to as app")
 	cmd.Flags().StringVar(&o.msg, "msg", "", "message to send, default scan input from stdin")
 	cmd.Flags().StringVar(&o.file, "file", "", "message to send in file")
+	cmd.Flags().StringVar(&o.template, "template", "", "go template file for rendering message")
+	cmd.Flags().StringVar(&o.data, "data", "", "JSON/YAML file as template data, \"-\" for reading JSON from stdin, default environment variables")
+	cmd.Flags().StringVar(&o.MsgType, "msg-type", "", "message type, one of text(default), post, image, interactive. For non-text types, message in JSON object is sent as raw content(or card)")
+	cmd.Flags().StringVar(&o.Title, "title", "", "title of post, or header title of interactive card")
+	cmd.Flags().StringVar(&o.Color, "color", "", "header color of interactive card, eg. red, orange, green, blue")
+	cmd.Flags().StringSliceVar(&o.At, "at", nil, "open_id or user_id to mention, \"all\" for everyone")
+	cmd.Flags().StringSliceVar(&o.images, "image", nil, "image file to upload and embed in message, sent as image if there's only one image and no message, otherwise post. Requires app credentials")
+	cmd.Flags().StringSliceVar(&o.attachments, "attach", nil, "file to upload and send following the message. Requires sending as app")
+	o.follow.AddFlags(cmd.Flags())
 
-	cmd.MarkFlagRequired("token")
 	return cmd
 }
 
-const webhookURI = "https://open.feishu.cn/open-apis/bot/v2/hook/%s"
-
-func genSign(secret string, timestamp int64) (string, error) {
-	stringToSign := fmt.Sprintf("%v", timestamp) + "\n" + secret
-	var data []byte
-	h := hmac.New(sha256.New, []byte(stringToSign))
-	_, err := h.Write(data)
-	if err != nil {
-		return "", err
+// runFollow forwards lines from stdin in batches until EOF or interrupted
+func (o *sendOptions) runFollow(b *bot) error {
+	if err := o.follow.complete(); err != nil {
+		return err
 	}
-
-	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
-	return signature, nil
-}
-
-type content struct {
-	Text string `json:"text"`
-}
-
-type payload struct {
-	Timestamp string  `json:"timestamp,omitempty"`
-	Sign      string  `json:"sign,omitempty"`
-	MsgType   string  `json:"msg_type"`
-	Content   content `json:"content,omitempty"`
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return o.follow.follow(ctx, os.Stdin, func(lines []string) {
+		m, err := b.build(o.messageSpec, strings.Join(lines, "\n"))
+		if err == nil {
+			// pending lines are still delivered after interrupted
+			err = b.send(context.Background(), m)
+		}
+		if err != nil {
+			log.GetLogger().Errorw("failed to forward lines", "lines", len(lines), "err", err)
+		}
+	})
 }
 
-func send(token string, sign string, msg string) (err error) {
-	pl := &payload{
-		MsgType: "text",
-		Content: content{msg},
-	}
-	if len(sign) > 0 {
-		now := time.Now().Unix()
-		pl.Timestamp = strconv.FormatInt(now, 10)
-		pl.Sign, err = genSign(sign, now)
+// resolveBot returns bot profile by name, or an anonymous bot when only token is given
+func (o *sendOptions) resolveBot() (*bot, error) {
+	b := &bot{}
+	if len(o.bot) > 0 {
+		cfg, err := o.loadConfig()
 		if err != nil {
-			return
+			return nil, err
 		}
+		profile, ok := cfg.Bots[o.bot]
+		if !ok {
+			return nil, fmt.Errorf("bot %s not found in config", o.bot)
+		}
+		*b = *profile
 	}
-	b, err := json.Marshal(pl)
-	if err != nil {
-		return err
+	if len(o.token) > 0 {
+		b.Token = o.token
 	}
-
-	resp, err := http.Post(fmt.Sprintf(webhookURI, token), "application/json", bytes.NewBuffer(b))
-	if err != nil {
-		return err
+	if len(o.sign) > 0 {
+		b.Secret = o.sign
 	}
-	if resp.StatusCode/100 >= 4 {
-		respContent, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return err
+	appID := o.appID
+	if len(appID) == 0 && len(o.bot) == 0 {
+		// environment variables don't turn webhook bot profiles into apps
+		appID = options.GetEnvWithDefault("FEISHU_APP_ID", "")
+	}
+	if len(appID) > 0 {
+		b.AppID, b.AppSecret = appID, o.appSecret
+		if len(b.AppSecret) == 0 {
+			b.AppSecret = options.GetEnvWithDefault("FEISHU_APP_SECRET", "")
 		}
-		return fmt.Errorf("unexpected status(%d): %v", resp.StatusCode, string(respContent))
 	}
-	return nil
+	if len(o.receiveIDType) > 0 {
+		b.ReceiveIDType = o.receiveIDType
+	}
+	if len(o.receiveID) > 0 {
+		b.ReceiveID = o.receiveID
+	}
+	if len(b.Token) == 0 && !b.IsApp() {
+		return nil, errors.New(`one of flag(s) "bot", "token" or "app-id" must be set`)
+	}
+	return b, nil
 }
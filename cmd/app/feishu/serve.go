@@ -1,12 +1,527 @@
 package feishu
 
-import "github.com/spf13/cobra"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
-// todo: message hub
-func newServeCommand() *cobra.Command {
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/cmd/app/options"
+	"github.com/fengxsong/toolkit/pkg/log"
+	"github.com/fengxsong/toolkit/pkg/notify"
+)
+
+type serveOptions struct {
+	*commonOptions
+	listenAddr      string
+	bots            []string
+	dataDir         string
+	workers         int
+	maxAttempts     int
+	dedupWindow     time.Duration
+	aggregateWindow time.Duration
+	shutdownTimeout time.Duration
+}
+
+func newServeCommand(co *commonOptions) *cobra.Command {
+	o := &serveOptions{commonOptions: co}
 	cmd := &cobra.Command{
 		Use:   "serve",
 		Short: "Serve HTTP handler for dealing messages",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return o.Run()
+		},
 	}
+	cmd.Flags().StringVar(&o.listenAddr, "listen", ":8080", "Address to listen on")
+	cmd.Flags().StringArrayVar(&o.bots, "bot", nil, "Feishu bot to forward messages to in addition to bot profiles in config, in format name=token[:sign], can be specified multiple times")
+	cmd.Flags().StringVar(&o.dataDir, "data-dir", "data", "Directory for storing queued messages and dead letters")
+	cmd.Flags().IntVar(&o.workers, "workers", 2, "Number of delivery workers")
+	cmd.Flags().IntVar(&o.maxAttempts, "max-attempts", defaultMaxAttempts, "Max delivery attempts before a message is moved to dead letters")
+	cmd.Flags().DurationVar(&o.dedupWindow, "dedup-window", 0, "Drop messages with the same content or dedup_key to the same bot within the window, disabled if zero")
+	cmd.Flags().DurationVar(&o.aggregateWindow, "aggregate-window", 0, "Combine text messages to the same bot arriving within the window into as few messages as size limits allow, disabled if zero")
+	cmd.Flags().DurationVar(&o.shutdownTimeout, "shutdown-timeout", 10*time.Second, "Timeout for graceful shutdown")
+	// for bot commands that talk to kubernetes
+	options.AddKubeContextFlags(cmd.Flags())
 	return cmd
 }
+
+// loadHubConfig loads config and adds bots given by flags
+func (o *serveOptions) loadHubConfig() (*config, error) {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range o.bots {
+		b, err := parseBot(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := cfg.Bots[b.name]; ok {
+			return nil, fmt.Errorf("duplicated bot name: %s", b.name)
+		}
+		cfg.Bots[b.name] = b
+	}
+	if len(cfg.Bots) == 0 {
+		return nil, errors.New("no bot configured")
+	}
+	if cfg.Events != nil {
+		if cfg.Events.VerificationToken == "" {
+			return nil, errors.New("verification token of events is required")
+		}
+		if _, ok := cfg.Bots[cfg.Events.ReplyBot]; !ok {
+			return nil, fmt.Errorf("reply bot of events not found: %s", cfg.Events.ReplyBot)
+		}
+	}
+	if cfg.Route != nil {
+		if err = cfg.Route.validate(cfg.Bots); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+const noClientWarning = "no client configured, authentication of API is disabled and admin API is closed"
+
+func (o *serveOptions) Run() error {
+	cfg, err := o.loadHubConfig()
+	if err != nil {
+		return err
+	}
+	h := &hub{eventDeduper: newDeduper(defaultEventDedupWindow)}
+	h.setConfig(cfg)
+	if len(cfg.Clients) == 0 {
+		log.GetLogger().Warn(noClientWarning)
+	}
+	if h.store, err = openStore(o.dataDir); err != nil {
+		return err
+	}
+	defer h.store.Close()
+	h.deliverer = newDeliverer(h.store, h.getBot, o.workers, o.maxAttempts)
+	h.deliverer.aggregateWindow = o.aggregateWindow
+	h.deduper = newDeduper(o.dedupWindow)
+
+	srv := &http.Server{
+		Addr:    o.listenAddr,
+		Handler: h.routes(),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go o.reloadOnSIGHUP(ctx, h)
+
+	deliverCtx, stopDeliver := context.WithCancel(context.Background())
+	delivered := make(chan struct{})
+	go func() {
+		h.deliverer.Run(deliverCtx)
+		close(delivered)
+	}()
+	defer func() {
+		stopDeliver()
+		<-delivered
+	}()
+	go h.runSilences(deliverCtx)
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.GetLogger().Infof("listening on %s", o.listenAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	log.GetLogger().Info("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// reloadOnSIGHUP reloads config when SIGHUP is received until ctx is done,
+// config is kept unchanged if it's invalid
+func (o *serveOptions) reloadOnSIGHUP(ctx context.Context, h *hub) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+		}
+		cfg, err := o.loadHubConfig()
+		if err != nil {
+			log.GetLogger().Errorw("failed to reload config", "err", err)
+			continue
+		}
+		// dropping all clients would disable authentication of a running hub
+		if len(cfg.Clients) == 0 && len(h.getClients()) > 0 {
+			log.GetLogger().Error("failed to reload config: no client configured while authentication is enabled, restart to disable it")
+			continue
+		}
+		h.setConfig(cfg)
+		if len(cfg.Clients) == 0 {
+			log.GetLogger().Warn(noClientWarning)
+		}
+		log.GetLogger().Infow("config reloaded", "bots", len(cfg.Bots), "clients", len(cfg.Clients))
+	}
+}
+
+// hub queues messages received over HTTP and forwards them to the configured bots
+type hub struct {
+	store     *store
+	deliverer *deliverer
+	deduper   *deduper
+
+	// mu guards fields below, which are replaced when config is reloaded
+	mu        sync.RWMutex
+	bots      map[string]*bot
+	events    *eventsConfig
+	clients   map[string]*client
+	rootRoute *route
+
+	eventDeduper *deduper
+}
+
+func (h *hub) setConfig(cfg *config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	setupLimiters(cfg.Clients, h.clients)
+	h.bots, h.events, h.clients, h.rootRoute = cfg.Bots, cfg.Events, cfg.Clients, cfg.Route
+}
+
+func (h *hub) getEvents() *eventsConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.events
+}
+
+func (h *hub) getClients() map[string]*client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clients
+}
+
+func (h *hub) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/messages", h.authenticate(false, h.handleMessage))
+	mux.HandleFunc("/api/v1/alertmanager", h.authenticate(false, h.handleAlertmanager))
+	for _, a := range adapters {
+		mux.HandleFunc("/api/v1/"+a.source, h.authenticate(false, h.handleAdapter(a)))
+	}
+	// events are verified by token or signature of feishu
+	mux.HandleFunc("/api/v1/events", h.handleEvents)
+	mux.HandleFunc("/api/v1/admin/dead-letters", h.authenticate(true, h.handleDeadLetters))
+	mux.HandleFunc("/api/v1/admin/dead-letters/replay", h.authenticate(true, h.handleReplay))
+	mux.HandleFunc("/api/v1/admin/silences", h.authenticate(true, h.handleSilences))
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	mux.Handle("/metrics", h.metricsHandler())
+	return mux
+}
+
+func (h *hub) getBot(name string) (*bot, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	b, ok := h.bots[name]
+	return b, ok
+}
+
+type apiMessage struct {
+	// Bots are the names of bots to deliver to, message is routed by labels if
+	// it's empty and routing is configured, otherwise it's delivered to all bots
+	Bots []string `json:"bots,omitempty"`
+	// Labels are used for routing and rendering template of route
+	Labels map[string]string `json:"labels,omitempty"`
+	Text   string            `json:"text,omitempty"`
+	// Content is the raw content of post/image, or card of interactive message
+	Content json.RawMessage `json:"content,omitempty"`
+	// DedupKey identifies duplicated messages, content hash is used if empty
+	DedupKey string `json:"dedup_key,omitempty"`
+	messageSpec
+	uploads
+}
+
+type response struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+func writeResponse(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	code := 0
+	if status/100 != 2 {
+		code = status
+	}
+	json.NewEncoder(w).Encode(&response{Code: code, Msg: msg})
+}
+
+func (h *hub) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var m apiMessage
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode message: %v", err))
+		return
+	}
+	body := m.Text
+	if len(m.Content) > 0 {
+		body = string(m.Content)
+	}
+	if len(body) == 0 && m.uploads.empty() {
+		writeResponse(w, http.StatusBadRequest, "empty message")
+		return
+	}
+	d := &delivery{bots: m.Bots, spec: m.messageSpec, body: body, dedupKey: m.DedupKey, uploads: &m.uploads}
+	deliveries, err := h.route(d, m.Labels, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	h.accept(w, r, deliveries...)
+}
+
+func (h *hub) lookup(names []string) ([]*bot, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(names) == 0 {
+		names = make([]string, 0, len(h.bots))
+		for name := range h.bots {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	targets := make([]*bot, 0, len(names))
+	for _, name := range names {
+		b, ok := h.bots[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown bot: %s", name)
+		}
+		targets = append(targets, b)
+	}
+	return targets, nil
+}
+
+// delivery is a message to deliver to bots
+type delivery struct {
+	// bots are names of bots to deliver to, bots allowed for client if empty
+	bots []string
+	spec messageSpec
+	body string
+	// dedupKey identifies duplicated messages, content hash is used if empty
+	dedupKey string
+	// uploads are uploaded for each bot, it may be nil
+	uploads *uploads
+	// labels are matched against silences
+	labels map[string]string
+}
+
+// statusError is an error with HTTP status of response
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return e.msg
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := err.(*statusError); ok {
+		status = e.status
+	}
+	writeResponse(w, status, err.Error())
+}
+
+// accept builds messages of deliveries for their bots, puts them into queue and
+// writes response. Duplicated messages are dropped, and silenced messages are
+// counted by their silences.
+func (h *hub) accept(w http.ResponseWriter, r *http.Request, deliveries ...*delivery) {
+	targets := make([][]*bot, len(deliveries))
+	for i, d := range deliveries {
+		t, err := h.targets(r, d.bots)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		targets[i] = t
+	}
+	silences, err := h.store.silences()
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var envelopes []*envelope
+	now := time.Now()
+	var duplicated, muted int
+	// keys are recorded after messages are queued, so that retries of failed
+	// requests are not taken as duplicated
+	var keys [][2]string
+	pending := make(map[[2]string]bool)
+	// build builds messages of delivery for bot unless they're duplicated, it
+	// returns false if the request has been responded with an error
+	build := func(d *delivery, b *bot, key string) bool {
+		k := [2]string{b.name, key}
+		if pending[k] || h.deduper.seen(b.name, key, now) {
+			messagesDuplicated.WithLabelValues(b.name).Inc()
+			duplicated++
+			return true
+		}
+		msgs, err := b.buildWithUploads(r.Context(), d.spec, d.body, d.uploads)
+		if err != nil {
+			status := http.StatusBadRequest
+			var re *notify.ResponseError
+			if errors.As(err, &re) {
+				status = http.StatusBadGateway
+			}
+			writeResponse(w, status, fmt.Sprintf("%s: %v", b.name, err))
+			return false
+		}
+		pending[k] = true
+		keys = append(keys, k)
+		for _, msg := range msgs {
+			envelopes = append(envelopes, &envelope{Bot: b.name, Message: msg, CreatedAt: now})
+		}
+		return true
+	}
+	type suppression struct {
+		d   *delivery
+		b   *bot
+		key string
+		sl  *silence
+	}
+	var suppressions []suppression
+	for i, d := range deliveries {
+		key := d.dedupKey
+		if key == "" {
+			key = d.contentKey()
+		}
+		for _, b := range targets[i] {
+			if sl := silenced(silences, d.labels, b.name, now); sl != nil {
+				// silenced messages are validated but not uploaded
+				if _, err := b.build(d.spec, d.body); err != nil {
+					writeResponse(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", b.name, err))
+					return
+				}
+				suppressions = append(suppressions, suppression{d: d, b: b, key: key, sl: sl})
+				continue
+			}
+			if !build(d, b, key) {
+				return
+			}
+		}
+	}
+	// suppressions are counted once the request is known to be valid
+	for _, sp := range suppressions {
+		err := h.store.suppress(sp.sl.ID, sp.b.name, sp.d.body)
+		if errors.Is(err, errSilenceNotFound) {
+			// silence expired since it was matched, deliver the message instead
+			if !build(sp.d, sp.b, sp.key) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		messagesSilenced.WithLabelValues(sp.b.name).Inc()
+		muted++
+	}
+	if len(envelopes) > 0 {
+		if err := h.store.enqueue(envelopes...); err != nil {
+			log.GetLogger().Errorw("failed to enqueue messages", "err", err)
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, k := range keys {
+			h.deduper.record(k[0], k[1], now)
+		}
+		for _, e := range envelopes {
+			messagesReceived.WithLabelValues(e.Bot).Inc()
+		}
+		h.deliverer.wakeup()
+	}
+	writeResponse(w, http.StatusAccepted, fmt.Sprintf("%d message(s) queued, %d duplicated, %d silenced", len(envelopes), duplicated, muted))
+}
+
+func parseIDs(r *http.Request) ([]uint64, error) {
+	var ids []uint64
+	for _, s := range r.URL.Query()["id"] {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid id: %s", s)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// handleDeadLetters lists(GET) or purges(DELETE) dead letters, ids are specified
+// by query parameter `id`, all dead letters are purged if no id is given
+func (h *hub) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		envelopes := make([]*envelope, 0)
+		if err := h.store.list(deadBucket, func(e *envelope) bool {
+			envelopes = append(envelopes, e)
+			return true
+		}); err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(envelopes)
+	case http.MethodDelete:
+		ids, err := parseIDs(r)
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		n, err := h.store.purge(ids...)
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeResponse(w, http.StatusOK, fmt.Sprintf("%d dead letter(s) purged", n))
+	default:
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleReplay moves dead letters back to queue, ids are specified by query
+// parameter `id`, all dead letters are replayed if no id is given
+func (h *hub) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ids, err := parseIDs(r)
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	n, err := h.store.replay(ids...)
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.deliverer.wakeup()
+	writeResponse(w, http.StatusOK, fmt.Sprintf("%d dead letter(s) replayed", n))
+}
@@ -0,0 +1,409 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+var (
+	silenceBucket = []byte("silences")
+
+	errSilenceNotFound = errors.New("silence not found")
+)
+
+const (
+	// maxSilenceSamples is the max number of suppressed messages kept for summary
+	maxSilenceSamples = 5
+	maxSampleLength   = 100
+
+	silenceCheckInterval = 10 * time.Second
+)
+
+// silence mutes messages whose labels match all matchers between StartsAt and EndsAt.
+// Besides labels of message, label `bot` is the name of target bot.
+type silence struct {
+	ID        uint64    `json:"id"`
+	Matchers  []string  `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	// Suppressed is the number of suppressed messages by bot
+	Suppressed map[string]int `json:"suppressed,omitempty"`
+	// Samples are the first lines of the first suppressed messages
+	Samples []string `json:"samples,omitempty"`
+
+	matchers []*matcher
+}
+
+func (s *silence) complete() error {
+	if len(s.Matchers) == 0 {
+		return errors.New("matchers are required")
+	}
+	if !s.EndsAt.After(s.StartsAt) {
+		return errors.New("end time must be after start time")
+	}
+	s.matchers = make([]*matcher, 0, len(s.Matchers))
+	for _, str := range s.Matchers {
+		m, err := parseMatcher(str)
+		if err != nil {
+			return err
+		}
+		s.matchers = append(s.matchers, m)
+	}
+	return nil
+}
+
+func (s *silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+func (s *silence) matches(labels map[string]string) bool {
+	for _, m := range s.matchers {
+		if !m.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *silence) summary() string {
+	bots := make([]string, 0, len(s.Suppressed))
+	total := 0
+	for bot, n := range s.Suppressed {
+		bots = append(bots, fmt.Sprintf("%s=%d", bot, n))
+		total += n
+	}
+	sort.Strings(bots)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "silence #%d {%s} expired, %d message(s) suppressed from %s to %s",
+		s.ID, strings.Join(s.Matchers, ", "), total, s.StartsAt.Local().Format("2006-01-02 15:04:05"), s.EndsAt.Local().Format("2006-01-02 15:04:05"))
+	if s.Comment != "" {
+		fmt.Fprintf(&sb, "\ncomment: %s", s.Comment)
+	}
+	fmt.Fprintf(&sb, "\nby bot: %s", strings.Join(bots, ", "))
+	for _, sample := range s.Samples {
+		fmt.Fprintf(&sb, "\n- %s", sample)
+	}
+	if total > len(s.Samples) {
+		fmt.Fprintf(&sb, "\n...")
+	}
+	return sb.String()
+}
+
+func sampleOf(body string) string {
+	line := strings.TrimSpace(strings.SplitN(strings.TrimSpace(body), "\n", 2)[0])
+	if r := []rune(line); len(r) > maxSampleLength {
+		line = string(r[:maxSampleLength]) + "..."
+	}
+	return line
+}
+
+func (s *store) addSilence(sl *silence) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(silenceBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		sl.ID = id
+		return putSilence(b, sl)
+	})
+}
+
+func putSilence(b *bolt.Bucket, sl *silence) error {
+	v, err := json.Marshal(sl)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(sl.ID), v)
+}
+
+// silences returns all silences, invalid ones are logged and skipped so that
+// a bad record doesn't block messages
+func (s *store) silences() ([]*silence, error) {
+	var silences []*silence
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(silenceBucket).ForEach(func(k, v []byte) error {
+			sl := &silence{}
+			if err := json.Unmarshal(v, sl); err != nil {
+				log.GetLogger().Warnw("skip invalid silence", "key", k, "err", err)
+				return nil
+			}
+			if err := sl.complete(); err != nil {
+				log.GetLogger().Warnw("skip invalid silence", "id", sl.ID, "err", err)
+				return nil
+			}
+			silences = append(silences, sl)
+			return nil
+		})
+	})
+	return silences, err
+}
+
+// pruneSilences deletes records of silences that can't be decoded or are invalid
+func (s *store) pruneSilences() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(silenceBucket)
+		var keys [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			sl := &silence{}
+			if err := json.Unmarshal(v, sl); err != nil {
+				log.GetLogger().Warnw("delete invalid silence", "key", k, "err", err)
+				keys = append(keys, k)
+				return nil
+			}
+			if err := sl.complete(); err != nil {
+				log.GetLogger().Warnw("delete invalid silence", "id", sl.ID, "err", err)
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateSilence updates silence by id with fn
+func (s *store) updateSilence(id uint64, fn func(sl *silence) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(silenceBucket)
+		v := b.Get(itob(id))
+		if v == nil {
+			return fmt.Errorf("%w: %d", errSilenceNotFound, id)
+		}
+		sl := &silence{}
+		if err := json.Unmarshal(v, sl); err != nil {
+			return err
+		}
+		if err := fn(sl); err != nil {
+			return err
+		}
+		return putSilence(b, sl)
+	})
+}
+
+// suppress counts message suppressed by silence
+func (s *store) suppress(id uint64, bot, body string) error {
+	return s.updateSilence(id, func(sl *silence) error {
+		if sl.Suppressed == nil {
+			sl.Suppressed = make(map[string]int)
+		}
+		sl.Suppressed[bot]++
+		if len(sl.Samples) < maxSilenceSamples {
+			sl.Samples = append(sl.Samples, sampleOf(body))
+		}
+		return nil
+	})
+}
+
+// expireSilence ends silence by id at now, silences that haven't started are
+// deleted as nothing has been suppressed by them
+func (s *store) expireSilence(id uint64, now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(silenceBucket)
+		v := b.Get(itob(id))
+		if v == nil {
+			return fmt.Errorf("%w: %d", errSilenceNotFound, id)
+		}
+		sl := &silence{}
+		if err := json.Unmarshal(v, sl); err != nil {
+			return err
+		}
+		if !now.After(sl.StartsAt) {
+			return b.Delete(itob(id))
+		}
+		if !sl.EndsAt.After(now) {
+			return nil
+		}
+		sl.EndsAt = now
+		return putSilence(b, sl)
+	})
+}
+
+func (s *store) deleteSilence(id uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(silenceBucket).Delete(itob(id))
+	})
+}
+
+// silenced returns the active silence that mutes message to bot, nil if none
+func silenced(silences []*silence, labels map[string]string, bot string, now time.Time) *silence {
+	if len(silences) == 0 {
+		return nil
+	}
+	withBot := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		withBot[k] = v
+	}
+	withBot["bot"] = bot
+	for _, sl := range silences {
+		if sl.active(now) && sl.matches(withBot) {
+			return sl
+		}
+	}
+	return nil
+}
+
+// runSilences sends summaries of expired silences to the bots whose messages were
+// suppressed, and removes expired silences until ctx is done
+func (h *hub) runSilences(ctx context.Context) {
+	ticker := time.NewTicker(silenceCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := h.expireSilences(time.Now()); err != nil {
+			log.GetLogger().Errorw("failed to expire silences", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *hub) expireSilences(now time.Time) error {
+	if err := h.store.pruneSilences(); err != nil {
+		return err
+	}
+	silences, err := h.store.silences()
+	if err != nil {
+		return err
+	}
+	for _, sl := range silences {
+		if now.Before(sl.EndsAt) {
+			continue
+		}
+		var envelopes []*envelope
+		if len(sl.Suppressed) > 0 {
+			text := sl.summary()
+			for name := range sl.Suppressed {
+				if _, ok := h.getBot(name); !ok {
+					continue
+				}
+				envelopes = append(envelopes, &envelope{Bot: name, Message: newTextMessage(text), CreatedAt: now})
+			}
+		}
+		if len(envelopes) > 0 {
+			if err = h.store.enqueue(envelopes...); err != nil {
+				return err
+			}
+			h.deliverer.wakeup()
+		}
+		if err = h.store.deleteSilence(sl.ID); err != nil {
+			return err
+		}
+		log.GetLogger().Infow("silence expired", "id", sl.ID, "matchers", sl.Matchers, "suppressed", sl.Suppressed)
+	}
+	return nil
+}
+
+// apiSilence is the request of creating silence, either duration or end time is required
+type apiSilence struct {
+	Matchers  []string  `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt,omitempty"`
+	EndsAt    time.Time `json:"endsAt,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+func (a *apiSilence) silence(now time.Time) (*silence, error) {
+	sl := &silence{
+		Matchers:  a.Matchers,
+		StartsAt:  a.StartsAt,
+		EndsAt:    a.EndsAt,
+		CreatedBy: a.CreatedBy,
+		Comment:   a.Comment,
+	}
+	if sl.StartsAt.IsZero() {
+		sl.StartsAt = now
+	}
+	if a.Duration != "" {
+		d, err := time.ParseDuration(a.Duration)
+		if err != nil {
+			return nil, err
+		}
+		sl.EndsAt = sl.StartsAt.Add(d)
+	}
+	if err := sl.complete(); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+// handleSilences lists(GET), creates(POST) or expires(DELETE) silences, ids of
+// silences to expire are specified by query parameter `id`
+func (h *hub) handleSilences(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := h.store.silences()
+		if err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if silences == nil {
+			silences = make([]*silence, 0)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(silences)
+	case http.MethodPost:
+		var a apiSilence
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			writeResponse(w, http.StatusBadRequest, fmt.Sprintf("decode silence: %v", err))
+			return
+		}
+		sl, err := a.silence(time.Now())
+		if err != nil {
+			writeResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if c := clientFrom(r); c != nil && sl.CreatedBy == "" {
+			sl.CreatedBy = c.name
+		}
+		if err = h.store.addSilence(sl); err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sl)
+	case http.MethodDelete:
+		ids, err := parseIDs(r)
+		if err != nil || len(ids) == 0 {
+			writeResponse(w, http.StatusBadRequest, "ids of silences are required")
+			return
+		}
+		now := time.Now()
+		for _, id := range ids {
+			if err = h.store.expireSilence(id, now); err != nil {
+				writeResponse(w, http.StatusNotFound, err.Error())
+				return
+			}
+		}
+		if err = h.expireSilences(now); err != nil {
+			writeResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeResponse(w, http.StatusOK, fmt.Sprintf("%d silence(s) expired", len(ids)))
+	default:
+		writeResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,157 @@
+package feishu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/cmd/app/options"
+)
+
+// hubClientOptions are options for talking to API of hub
+type hubClientOptions struct {
+	server  string
+	token   string
+	timeout time.Duration
+}
+
+func (o *hubClientOptions) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(o.server, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.token != "" {
+		req.Header.Set("Authorization", "Bearer "+o.token)
+	}
+	resp, err := (&http.Client{Timeout: o.timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		var r response
+		if json.Unmarshal(b, &r) == nil && r.Msg != "" {
+			return fmt.Errorf("%s: %s", resp.Status, r.Msg)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func newSilenceCommand() *cobra.Command {
+	o := &hubClientOptions{}
+	cmd := &cobra.Command{
+		Use:   "silence",
+		Short: "Manage silences of feishu hub, which mute messages matching labels for a period",
+	}
+	cmd.PersistentFlags().StringVar(&o.server, "server", options.GetEnvWithDefault("FEISHU_HUB_URL", "http://127.0.0.1:8080"), "Address of feishu hub")
+	cmd.PersistentFlags().StringVar(&o.token, "token", options.GetEnvWithDefault("FEISHU_HUB_TOKEN", ""), "Token of admin client of hub")
+	cmd.PersistentFlags().DurationVar(&o.timeout, "timeout", 10*time.Second, "Timeout of requests to hub")
+
+	{
+		a := &apiSilence{}
+		var duration time.Duration
+		addCmd := &cobra.Command{
+			Use:   "add",
+			Short: "Add silence, eg. silence add --match team=infra --match bot=ops --duration 2h",
+			Args:  cobra.NoArgs,
+			RunE: func(_ *cobra.Command, _ []string) error {
+				if len(a.Matchers) == 0 {
+					return fmt.Errorf("at least one matcher is required")
+				}
+				if duration <= 0 {
+					return fmt.Errorf("duration must be positive")
+				}
+				for _, s := range a.Matchers {
+					if _, err := parseMatcher(s); err != nil {
+						return err
+					}
+				}
+				a.Duration = duration.String()
+				sl := &silence{}
+				if err := o.do(http.MethodPost, "/api/v1/admin/silences", a, sl); err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "silence %d added, expires at %s\n", sl.ID, sl.EndsAt.Local().Format(time.RFC3339))
+				return nil
+			},
+		}
+		addCmd.Flags().StringArrayVar(&a.Matchers, "match", nil, "Label matcher in format of name=value, name!=value, name=~regexp or name!~regexp, label bot is the target bot, can be specified multiple times")
+		addCmd.Flags().DurationVar(&duration, "duration", time.Hour, "Duration of silence")
+		addCmd.Flags().StringVar(&a.Comment, "comment", "", "Comment of silence")
+		addCmd.Flags().StringVar(&a.CreatedBy, "created-by", options.GetEnvWithDefault("USER", ""), "Creator of silence")
+		cmd.AddCommand(addCmd)
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "list",
+		Short:   "List silences",
+		Aliases: []string{"ls"},
+		Args:    cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			var silences []*silence
+			if err := o.do(http.MethodGet, "/api/v1/admin/silences", nil, &silences); err != nil {
+				return err
+			}
+			now := time.Now()
+			tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(tw, "ID\tMATCHERS\tSTATE\tENDS\tSUPPRESSED\tCREATED BY\tCOMMENT")
+			for _, sl := range silences {
+				state := "active"
+				if now.Before(sl.StartsAt) {
+					state = "pending"
+				} else if !now.Before(sl.EndsAt) {
+					state = "expired"
+				}
+				total := 0
+				for _, n := range sl.Suppressed {
+					total += n
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\t%s\n", sl.ID, strings.Join(sl.Matchers, ","), state,
+					sl.EndsAt.Local().Format(time.RFC3339), total, sl.CreatedBy, sl.Comment)
+			}
+			return tw.Flush()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "expire ID...",
+		Short: "Expire silences, summaries of suppressed messages are sent right away",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			var r response
+			if err := o.do(http.MethodDelete, "/api/v1/admin/silences?"+url.Values{"id": args}.Encode(), nil, &r); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stdout, r.Msg)
+			return nil
+		},
+	})
+	return cmd
+}
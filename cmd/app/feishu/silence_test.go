@@ -0,0 +1,120 @@
+package feishu
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+func TestMain(m *testing.M) {
+	if err := log.InitLogger(true); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExpirePendingSilence(t *testing.T) {
+	s, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	h := &hub{store: s}
+
+	now := time.Now()
+	pending := &silence{Matchers: []string{"team=infra"}, StartsAt: now.Add(time.Hour), EndsAt: now.Add(2 * time.Hour)}
+	if err = pending.complete(); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.addSilence(pending); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.handleSilences(w, httptest.NewRequest(http.MethodDelete, "/api/v1/admin/silences?id=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	silences, err := s.silences()
+	if err != nil {
+		t.Fatalf("list silences: %v", err)
+	}
+	if len(silences) != 0 {
+		t.Fatalf("expected pending silence to be deleted, got %d silence(s)", len(silences))
+	}
+}
+
+func TestSilencesSkipInvalid(t *testing.T) {
+	s, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	valid := &silence{Matchers: []string{"team=infra"}, StartsAt: now, EndsAt: now.Add(time.Hour)}
+	if err = s.addSilence(valid); err != nil {
+		t.Fatal(err)
+	}
+	// a silence whose end time is not after its start time, and a broken record
+	invalid := &silence{ID: 2, Matchers: []string{"team=infra"}, StartsAt: now, EndsAt: now}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(silenceBucket)
+		if err := putSilence(b, invalid); err != nil {
+			return err
+		}
+		return b.Put(itob(3), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	silences, err := s.silences()
+	if err != nil {
+		t.Fatalf("list silences: %v", err)
+	}
+	if len(silences) != 1 || silences[0].ID != valid.ID {
+		t.Fatalf("expected only silence %d, got %d silence(s)", valid.ID, len(silences))
+	}
+}
+
+func TestPruneSilences(t *testing.T) {
+	s, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	valid := &silence{Matchers: []string{"team=infra"}, StartsAt: now, EndsAt: now.Add(time.Hour)}
+	if err = s.addSilence(valid); err != nil {
+		t.Fatal(err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(silenceBucket).Put(itob(2), []byte("{"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.pruneSilences(); err != nil {
+		t.Fatalf("prune silences: %v", err)
+	}
+	var keys int
+	s.db.View(func(tx *bolt.Tx) error {
+		keys = tx.Bucket(silenceBucket).Stats().KeyN
+		return nil
+	})
+	if keys != 1 {
+		t.Fatalf("expected 1 silence record left, got %d", keys)
+	}
+	if err = s.suppress(2, "ops", "hello"); !errors.Is(err, errSilenceNotFound) {
+		t.Fatalf("expected error of silence not found, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package feishu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	tmplfuncs "github.com/fengxsong/toolkit/pkg/template"
+)
+
+var templateFuncs = func() template.FuncMap {
+	funcs := tmplfuncs.FuncMap()
+	funcs["sortedPairs"] = sortedPairs
+	funcs["timeFormat"] = func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	}
+	funcs["trim"] = strings.TrimSpace
+	funcs["join"] = func(sep string, elems []interface{}) string {
+		ss := make([]string, 0, len(elems))
+		for _, e := range elems {
+			ss = append(ss, fmt.Sprint(e))
+		}
+		return strings.Join(ss, sep)
+	}
+	return funcs
+}()
+
+func sortedPairs(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, m[k]))
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// environ returns environment variables in map
+func environ() map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) == 2 {
+			m[pair[0]] = pair[1]
+		}
+	}
+	return m
+}
+
+// loadTemplateData loads data for rendering templates from file, `-` for reading
+// JSON from stdin. Environment variables are used when fn is empty.
+func loadTemplateData(fn string, stdin io.Reader) (interface{}, error) {
+	if fn == "" {
+		return environ(), nil
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if fn == "-" {
+		b, err = ioutil.ReadAll(stdin)
+	} else {
+		b, err = ioutil.ReadFile(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	switch ext := filepath.Ext(fn); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &data)
+	case ".json", "":
+		err = json.Unmarshal(b, &data)
+	default:
+		return nil, fmt.Errorf("unknown file extension: %s", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode template data: %v", err)
+	}
+	return data, nil
+}
+
+func renderTemplateFile(fn string, data interface{}) (string, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return "", err
+	}
+	tmpl, err := template.New(filepath.Base(fn)).Funcs(templateFuncs).Option("missingkey=zero").Parse(string(b))
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err = tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -5,4 +5,5 @@ import (
 	_ "github.com/fengxsong/toolkit/cmd/app/es"
 	_ "github.com/fengxsong/toolkit/cmd/app/feishu"
 	_ "github.com/fengxsong/toolkit/cmd/app/kube"
+	_ "github.com/fengxsong/toolkit/cmd/app/notify"
 )
@@ -1,6 +1,7 @@
 package kube
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -33,7 +34,7 @@ func check(o *options.KubeListOption, filename string) error {
 	if err != nil {
 		return err
 	}
-	currentItems, err := cli.listDeployments(o)
+	currentItems, err := cli.listDeployments(context.Background(), o)
 	if err != nil {
 		return err
 	}
@@ -46,8 +46,8 @@ func newClient() (*client, error) {
 	return &client{kubeClient}, nil
 }
 
-func (c *client) listDeploymentObjects(o *options.KubeListOption) ([]appsv1.Deployment, error) {
-	list, err := c.kubeClient.AppsV1().Deployments(o.Namespace).List(context.Background(),
+func (c *client) listDeploymentObjects(ctx context.Context, o *options.KubeListOption) ([]appsv1.Deployment, error) {
+	list, err := c.kubeClient.AppsV1().Deployments(o.Namespace).List(ctx,
 		metav1.ListOptions{FieldSelector: o.FieldSelector, LabelSelector: o.LabelSelector})
 	if err != nil {
 		return nil, err
@@ -55,8 +55,8 @@ func (c *client) listDeploymentObjects(o *options.KubeListOption) ([]appsv1.Depl
 	return list.Items, nil
 }
 
-func (c *client) listDeployments(o *options.KubeListOption) (deploymentList, error) {
-	list, err := c.listDeploymentObjects(o)
+func (c *client) listDeployments(ctx context.Context, o *options.KubeListOption) (deploymentList, error) {
+	list, err := c.listDeploymentObjects(ctx, o)
 	if err != nil {
 		return nil, err
 	}
@@ -1,8 +1,12 @@
 package kube
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"sort"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 
@@ -31,18 +35,50 @@ func newListCommand() *cobra.Command {
 			return nil
 		},
 		RunE: func(_ *cobra.Command, _ []string) error {
-			cli, err := newClient()
-			if err != nil {
-				return err
-			}
-			items, err := cli.listDeployments(listOption)
-			if err != nil {
-				return err
-			}
-			return items.Write(w)
+			return ListDeployments(context.Background(), listOption, w)
 		},
 	}
 	listOption.AddFlags(cmd.Flags())
 	cmd.Flags().StringVarP(&out, "out", "o", "", "Write objects to file or stdout")
 	return cmd
 }
+
+// ListDeployments lists deployments and writes them to w in yaml
+func ListDeployments(ctx context.Context, o *options.KubeListOption, w io.Writer) error {
+	cli, err := newClient()
+	if err != nil {
+		return err
+	}
+	items, err := cli.listDeployments(ctx, o)
+	if err != nil {
+		return err
+	}
+	return items.Write(w)
+}
+
+// SummarizeDeployments lists deployments and writes one line of name and
+// replicas for each of them to w
+func SummarizeDeployments(ctx context.Context, o *options.KubeListOption, w io.Writer) error {
+	cli, err := newClient()
+	if err != nil {
+		return err
+	}
+	items, err := cli.listDeploymentObjects(ctx, o)
+	if err != nil {
+		return err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tREADY\tUP-TO-DATE\tAVAILABLE")
+	for _, d := range items {
+		var replicas int32
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		fmt.Fprintf(tw, "%s\t%d/%d\t%d\t%d\n", d.Name, d.Status.ReadyReplicas, replicas,
+			d.Status.UpdatedReplicas, d.Status.AvailableReplicas)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/fengxsong/toolkit/cmd/app/factory"
+)
+
+const name = "notify"
+
+func init() {
+	factory.Register(name, newSubCommand())
+}
+
+func newSubCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: "notify for sending messages to feishu, dingtalk, wecom and slack",
+	}
+	o := &commonOptions{}
+	o.AddFlags(cmd.PersistentFlags())
+	cmd.AddCommand(newSendCommand(o))
+	return cmd
+}
@@ -0,0 +1,108 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/fengxsong/toolkit/cmd/app/options"
+	"github.com/fengxsong/toolkit/internal/errors"
+	"github.com/fengxsong/toolkit/pkg/log"
+	"github.com/fengxsong/toolkit/pkg/notify"
+)
+
+const defaultConfigFile = "~/.toolkit/notify.yaml"
+
+type commonOptions struct {
+	configFile string
+}
+
+func (o *commonOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.configFile, "config", defaultConfigFile, fmt.Sprintf("Config file that defines channels, supported types: %s", strings.Join(notify.Types(), ", ")))
+}
+
+func (o *commonOptions) loadChannels() (map[string]notify.Notifier, error) {
+	fn, err := options.ExpandHome(o.configFile)
+	if err != nil {
+		return nil, err
+	}
+	return notify.LoadConfig(fn)
+}
+
+type sendOptions struct {
+	*commonOptions
+	channels []string
+	msg      string
+	file     string
+	notify.Message
+}
+
+func newSendCommand(co *commonOptions) *cobra.Command {
+	o := &sendOptions{commonOptions: co}
+	cmd := &cobra.Command{
+		Use:   "send",
+		Short: "Send message to channels defined in config",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return o.Run()
+		},
+	}
+	cmd.Flags().StringSliceVar(&o.channels, "channel", nil, "name of channel defined in config, can be specified multiple times")
+	cmd.Flags().StringVar(&o.msg, "msg", "", "message to send, \"-\" for reading from stdin")
+	cmd.Flags().StringVar(&o.file, "file", "", "message to send in file")
+	cmd.Flags().StringVar(&o.Title, "title", "", "title of message, message is sent as markdown(or card) when it's set")
+	cmd.Flags().StringSliceVar(&o.At, "at", nil, "id of user to mention, \"all\" for everyone")
+	cmd.MarkFlagRequired("channel")
+
+	return cmd
+}
+
+func (o *sendOptions) Run() error {
+	channels, err := o.loadChannels()
+	if err != nil {
+		return err
+	}
+	notifiers := make(map[string]notify.Notifier, len(o.channels))
+	for _, name := range o.channels {
+		n, ok := channels[name]
+		if !ok {
+			return fmt.Errorf("channel %s not found in config", name)
+		}
+		notifiers[name] = n
+	}
+	switch {
+	case len(o.file) > 0:
+		b, err := ioutil.ReadFile(o.file)
+		if err != nil {
+			return err
+		}
+		o.Text = string(b)
+	case o.msg == "-":
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		o.Text = string(b)
+	default:
+		o.Text = strings.ReplaceAll(o.msg, "\\n", "\n")
+	}
+	if len(strings.TrimSpace(o.Text)) == 0 {
+		return nil
+	}
+	var errs []error
+	for name, n := range notifiers {
+		if err = n.Notify(context.Background(), &o.Message); err != nil {
+			errs = append(errs, fmt.Errorf("channel %s: %v", name, err))
+			continue
+		}
+		log.GetLogger().Infof("message has been sent to channel `%s`", name)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.MultiError(errs)
+}
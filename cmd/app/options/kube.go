@@ -13,6 +13,12 @@ func AddKubeConfigFlags(fs *pflag.FlagSet) {
 	configFlags.AddFlags(fs)
 }
 
+// AddKubeContextFlags add flags for choosing kubeconfig and context only
+func AddKubeContextFlags(fs *pflag.FlagSet) {
+	fs.StringVar(configFlags.KubeConfig, "kubeconfig", *configFlags.KubeConfig, "Path to the kubeconfig file to use for CLI requests.")
+	fs.StringVar(configFlags.Context, "context", *configFlags.Context, "The name of the kubeconfig context to use")
+}
+
 // RESTClientGetter
 func RESTClientGetter() genericclioptions.RESTClientGetter {
 	return configFlags
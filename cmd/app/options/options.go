@@ -2,6 +2,8 @@ package options
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -13,6 +15,18 @@ func GetEnvWithDefault(key string, defVal string) string {
 	return defVal
 }
 
+// ExpandHome expands leading `~/` of path to home directory of current user
+func ExpandHome(fn string) (string, error) {
+	if !strings.HasPrefix(fn, "~/") {
+		return fn, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, fn[2:]), nil
+}
+
 func ExecuteRootPersistentPreRunE(cmd *cobra.Command, args []string) error {
 	if root := cmd.Root(); root != nil {
 		if root.PersistentPreRunE != nil {
@@ -5,10 +5,13 @@ go 1.16
 require (
 	github.com/aliyun/aliyun-log-go-sdk v0.1.20
 	github.com/go-test/deep v1.0.7
+	github.com/prometheus/client_golang v1.11.0
 	github.com/spf13/cobra v1.1.1
 	github.com/spf13/pflag v1.0.5
+	go.etcd.io/bbolt v1.3.6
 	go.uber.org/multierr v1.5.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	k8s.io/api v0.21.0
 	k8s.io/apimachinery v0.21.0
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	dingtalkWebhookURI = "https://oapi.dingtalk.com/robot/send"
+	// custom robot is limited to 20 messages per minute
+	dingtalkRateLimit = rate.Limit(20.0 / 60)
+	dingtalkRateBurst = 5
+
+	codeDingtalkSendTooFast = 130101
+)
+
+func init() {
+	Register("dingtalk", func(unmarshal func(interface{}) error) (Notifier, error) {
+		d := &DingTalk{}
+		if err := unmarshal(d); err != nil {
+			return nil, err
+		}
+		if d.Token == "" {
+			return nil, errors.New("token is required")
+		}
+		return d, nil
+	})
+}
+
+// DingTalk is a custom robot of dingtalk group
+// https://open.dingtalk.com/document/robots/custom-robot-access
+type DingTalk struct {
+	Token string `yaml:"token"`
+	// Secret for signing requests, optional
+	Secret string `yaml:"secret,omitempty"`
+}
+
+// Notify sends message as markdown if it has title, otherwise as text. Mentions
+// are phone numbers of users.
+func (d *DingTalk) Notify(ctx context.Context, msg *Message) error {
+	at := map[string]interface{}{}
+	var mobiles []string
+	for _, id := range msg.At {
+		if id == "all" {
+			at["isAtAll"] = true
+			continue
+		}
+		mobiles = append(mobiles, id)
+	}
+	if len(mobiles) > 0 {
+		at["atMobiles"] = mobiles
+	}
+	text := msg.Text
+	for _, m := range mobiles {
+		text += " @" + m
+	}
+	pl := map[string]interface{}{"at": at}
+	if msg.Title != "" {
+		pl["msgtype"] = "markdown"
+		pl["markdown"] = map[string]string{"title": msg.Title, "text": fmt.Sprintf("### %s\n%s", msg.Title, text)}
+	} else {
+		pl["msgtype"] = "text"
+		pl["text"] = map[string]string{"content": text}
+	}
+	return withRetry(ctx, limiterFor("dingtalk", d.Token, dingtalkRateLimit, dingtalkRateBurst), func() error {
+		uri, err := d.webhookURI(time.Now())
+		if err != nil {
+			return err
+		}
+		return postJSON(ctx, endpoint{"dingtalk", "webhook"}, uri, nil, pl, checkErrCode(codeDingtalkSendTooFast))
+	})
+}
+
+func (d *DingTalk) webhookURI(now time.Time) (string, error) {
+	params := url.Values{"access_token": []string{d.Token}}
+	if d.Secret != "" {
+		timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+		h := hmac.New(sha256.New, []byte(d.Secret))
+		if _, err := h.Write([]byte(timestamp + "\n" + d.Secret)); err != nil {
+			return "", err
+		}
+		params.Set("timestamp", timestamp)
+		params.Set("sign", base64.StdEncoding.EncodeToString(h.Sum(nil)))
+	}
+	return dingtalkWebhookURI + "?" + params.Encode(), nil
+}
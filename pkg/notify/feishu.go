@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	FeishuMsgTypeText        = "text"
+	FeishuMsgTypePost        = "post"
+	FeishuMsgTypeImage       = "image"
+	FeishuMsgTypeInteractive = "interactive"
+	FeishuMsgTypeFile        = "file"
+)
+
+// FeishuMessage is the body of feishu bot message
+// https://open.feishu.cn/document/ukTMukTMukTM/ucTM5YjL3ETO24yNxkjN
+type FeishuMessage struct {
+	MsgType string          `json:"msg_type"`
+	Content json.RawMessage `json:"content,omitempty"`
+	Card    json.RawMessage `json:"card,omitempty"`
+}
+
+// NewFeishuTextMessage returns text message
+func NewFeishuTextMessage(text string) *FeishuMessage {
+	b, _ := json.Marshal(map[string]string{"text": text})
+	return &FeishuMessage{MsgType: FeishuMsgTypeText, Content: b}
+}
+
+func init() {
+	Register("feishu", func(unmarshal func(interface{}) error) (Notifier, error) {
+		f := &Feishu{}
+		if err := unmarshal(f); err != nil {
+			return nil, err
+		}
+		if f.Token == "" && !f.IsApp() {
+			return nil, errors.New("token or appID is required")
+		}
+		return f, nil
+	})
+}
+
+// Feishu is either a custom bot with webhook token, or an app that sends
+// messages through open api. A custom bot may have credentials of app as well
+// for uploading images.
+type Feishu struct {
+	Token  string `yaml:"token,omitempty"`
+	Secret string `yaml:"secret,omitempty"`
+
+	AppID     string `yaml:"appID,omitempty"`
+	AppSecret string `yaml:"appSecret,omitempty"`
+	// ReceiveIDType is one of chat_id(default), open_id, user_id, union_id and email
+	ReceiveIDType string `yaml:"receiveIDType,omitempty"`
+	ReceiveID     string `yaml:"receiveID,omitempty"`
+}
+
+// IsApp reports whether it has credentials of app
+func (f *Feishu) IsApp() bool {
+	return len(f.AppID) > 0
+}
+
+// SendsAsApp reports whether messages are sent through open api, messages are
+// sent through webhook if it has token but no receiver
+func (f *Feishu) SendsAsApp() bool {
+	return f.IsApp() && (len(f.Token) == 0 || len(f.ReceiveID) > 0)
+}
+
+// Notify sends message as text, or interactive card with lark_md if it has title
+func (f *Feishu) Notify(ctx context.Context, msg *Message) error {
+	if msg.Title == "" {
+		var sb strings.Builder
+		sb.WriteString(msg.Text)
+		for _, id := range msg.At {
+			fmt.Fprintf(&sb, ` <at user_id="%s"></at>`, id)
+		}
+		return f.Send(ctx, NewFeishuTextMessage(sb.String()))
+	}
+	var sb strings.Builder
+	sb.WriteString(msg.Text)
+	for _, id := range msg.At {
+		fmt.Fprintf(&sb, " <at id=%s></at>", id)
+	}
+	card, err := json.Marshal(map[string]interface{}{
+		"config": map[string]interface{}{"wide_screen_mode": true},
+		"header": map[string]interface{}{
+			"title": map[string]string{"tag": "plain_text", "content": msg.Title},
+		},
+		"elements": []interface{}{
+			map[string]interface{}{
+				"tag":  "div",
+				"text": map[string]string{"tag": "lark_md", "content": sb.String()},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return f.Send(ctx, &FeishuMessage{MsgType: FeishuMsgTypeInteractive, Card: card})
+}
+
+// Send sends feishu message through webhook, or open api if it's an app
+func (f *Feishu) Send(ctx context.Context, msg *FeishuMessage) error {
+	if f.SendsAsApp() {
+		return sendAsApp(ctx, f.AppID, f.AppSecret, f.ReceiveIDType, f.ReceiveID, msg)
+	}
+	return withRetry(ctx, limiterFor("feishu", f.Token, feishuRateLimit, feishuRateBurst), func() error {
+		return sendFeishuWebhook(ctx, f.Token, f.Secret, msg)
+	})
+}
+
+const (
+	feishuWebhookURI = "https://open.feishu.cn/open-apis/bot/v2/hook/%s"
+	// custom bot is limited to 100 requests per minute and 5 requests per second
+	feishuRateLimit = rate.Limit(100.0 / 60)
+	feishuRateBurst = 5
+
+	codeTooManyRequest = 9499
+)
+
+func genFeishuSign(secret string, timestamp int64) (string, error) {
+	stringToSign := fmt.Sprintf("%v", timestamp) + "\n" + secret
+	var data []byte
+	h := hmac.New(sha256.New, []byte(stringToSign))
+	_, err := h.Write(data)
+	if err != nil {
+		return "", err
+	}
+
+	signature := base64.StdEncoding.EncodeToString(h.Sum(nil))
+	return signature, nil
+}
+
+type feishuPayload struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Sign      string `json:"sign,omitempty"`
+	*FeishuMessage
+}
+
+func sendFeishuWebhook(ctx context.Context, token string, sign string, msg *FeishuMessage) (err error) {
+	pl := &feishuPayload{FeishuMessage: msg}
+	if len(sign) > 0 {
+		now := time.Now().Unix()
+		pl.Timestamp = strconv.FormatInt(now, 10)
+		pl.Sign, err = genFeishuSign(sign, now)
+		if err != nil {
+			return
+		}
+	}
+	return postJSON(ctx, endpoint{"feishu", "webhook"}, fmt.Sprintf(feishuWebhookURI, token), nil, pl, checkFeishu(nil))
+}
+
+// checkFeishu checks responses of feishu webhook and open api, and decodes
+// successful response into out if it's not nil
+func checkFeishu(out interface{}) checkFunc {
+	return func(statusCode int, body []byte) error {
+		var result struct {
+			Code          int    `json:"code"`
+			Msg           string `json:"msg"`
+			StatusCode    int    `json:"StatusCode"`
+			StatusMessage string `json:"StatusMessage"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			if statusCode/100 != 2 {
+				return &ResponseError{StatusCode: statusCode, Msg: string(body)}
+			}
+			return fmt.Errorf("decode response: %v", err)
+		}
+		if result.Code == 0 && result.StatusCode != 0 {
+			result.Code, result.Msg = result.StatusCode, result.StatusMessage
+		}
+		if result.Code != 0 || statusCode/100 != 2 {
+			e := &ResponseError{StatusCode: statusCode, Code: result.Code, Msg: result.Msg}
+			switch result.Code {
+			case codeTooManyRequest, codeOpenAPIRateLimited, codeAccessTokenInvalid, codeAccessTokenExpired:
+				e.Temporary = true
+			}
+			return e
+		}
+		if out != nil {
+			return json.Unmarshal(body, out)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,250 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	openAPIBase          = "https://open.feishu.cn/open-apis"
+	tenantAccessTokenURI = openAPIBase + "/auth/v3/tenant_access_token/internal"
+	messagesURI          = openAPIBase + "/im/v1/messages"
+	imagesURI            = openAPIBase + "/im/v1/images"
+	filesURI             = openAPIBase + "/im/v1/files"
+
+	// MaxFeishuImageSize is the max size of image to upload
+	MaxFeishuImageSize = 10 << 20
+	// MaxFeishuFileSize is the max size of file to upload
+	MaxFeishuFileSize = 30 << 20
+
+	// message sending of app is limited to 50 requests per second
+	appRateLimit = rate.Limit(50)
+	appRateBurst = 50
+	// refresh access token before it's expired
+	tokenRefreshMargin = 5 * time.Minute
+
+	codeOpenAPIRateLimited = 99991400
+	codeAccessTokenInvalid = 99991663
+	codeAccessTokenExpired = 99991677
+)
+
+var receiveIDTypes = map[string]struct{}{
+	"chat_id":  {},
+	"open_id":  {},
+	"user_id":  {},
+	"union_id": {},
+	"email":    {},
+}
+
+// tenantAccessToken is the cached token of app, mu serializes refreshing of the
+// same app so that apps don't wait for each other
+type tenantAccessToken struct {
+	mu       sync.Mutex
+	token    string
+	expireAt time.Time
+}
+
+var (
+	tokenMu sync.Mutex
+	tokens  = make(map[string]*tenantAccessToken)
+)
+
+func tokenOf(appID string) *tenantAccessToken {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	t, ok := tokens[appID]
+	if !ok {
+		t = &tenantAccessToken{}
+		tokens[appID] = t
+	}
+	return t
+}
+
+// getTenantAccessToken returns cached tenant_access_token of app, it's refreshed
+// when it's about to expire
+func getTenantAccessToken(ctx context.Context, appID, appSecret string) (string, error) {
+	t := tokenOf(appID)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Now().Before(t.expireAt) {
+		return t.token, nil
+	}
+	var result struct {
+		TenantAccessToken string `json:"tenant_access_token"`
+		Expire            int    `json:"expire"`
+	}
+	in := map[string]string{"app_id": appID, "app_secret": appSecret}
+	if err := postJSON(ctx, endpoint{"feishu", "tenant_access_token"}, tenantAccessTokenURI, nil, in, checkFeishu(&result)); err != nil {
+		return "", err
+	}
+	lifetime := time.Duration(result.Expire) * time.Second
+	// tokens close to expiry are returned with short lifetime, which is still usable
+	margin := tokenRefreshMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	t.token, t.expireAt = result.TenantAccessToken, time.Now().Add(lifetime-margin)
+	return t.token, nil
+}
+
+func invalidateTenantAccessToken(appID string) {
+	t := tokenOf(appID)
+	t.mu.Lock()
+	t.token = ""
+	t.mu.Unlock()
+}
+
+// openAPIContent converts message of webhook into content of open api
+func openAPIContent(msg *FeishuMessage) (string, error) {
+	switch msg.MsgType {
+	case FeishuMsgTypeInteractive:
+		return string(msg.Card), nil
+	case FeishuMsgTypePost:
+		// content of post is not wrapped by `post` in open api
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(msg.Content, &m); err != nil {
+			return "", err
+		}
+		if post, ok := m["post"]; ok {
+			return string(post), nil
+		}
+	}
+	return string(msg.Content), nil
+}
+
+// sendAsApp sends message to receiver through open api with credentials of app
+// https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/message/create
+func sendAsApp(ctx context.Context, appID, appSecret, receiveIDType, receiveID string, msg *FeishuMessage) error {
+	if len(receiveID) == 0 {
+		return errors.New("receive id is required for sending message as app")
+	}
+	if receiveIDType == "" {
+		receiveIDType = "chat_id"
+	}
+	if _, ok := receiveIDTypes[receiveIDType]; !ok {
+		return fmt.Errorf("unsupported receive id type: %s", receiveIDType)
+	}
+	content, err := openAPIContent(msg)
+	if err != nil {
+		return err
+	}
+	in := map[string]string{
+		"receive_id": receiveID,
+		"msg_type":   msg.MsgType,
+		"content":    content,
+	}
+	uri := messagesURI + "?" + url.Values{"receive_id_type": []string{receiveIDType}}.Encode()
+	return withRetry(ctx, limiterFor("feishu-app", appID, appRateLimit, appRateBurst), func() error {
+		token, err := getTenantAccessToken(ctx, appID, appSecret)
+		if err != nil {
+			return err
+		}
+		header := http.Header{"Authorization": []string{"Bearer " + token}}
+		err = postJSON(ctx, endpoint{"feishu", "messages"}, uri, header, in, checkFeishu(nil))
+		if e, ok := err.(*ResponseError); ok && (e.Code == codeAccessTokenInvalid || e.Code == codeAccessTokenExpired) {
+			invalidateTenantAccessToken(appID)
+		}
+		return err
+	})
+}
+
+// fileTypes maps extension of file to file_type of upload api, others are uploaded as stream
+var fileTypes = map[string]string{
+	".opus": "opus",
+	".mp4":  "mp4",
+	".pdf":  "pdf",
+	".doc":  "doc",
+	".docx": "doc",
+	".xls":  "xls",
+	".xlsx": "xls",
+	".ppt":  "ppt",
+	".pptx": "ppt",
+}
+
+// UploadImage uploads image with credentials of app and returns its image_key
+// https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/image/create
+func (f *Feishu) UploadImage(ctx context.Context, name string, data []byte) (string, error) {
+	if len(data) > MaxFeishuImageSize {
+		return "", fmt.Errorf("image %s exceeds %d bytes", name, MaxFeishuImageSize)
+	}
+	var result struct {
+		Data struct {
+			ImageKey string `json:"image_key"`
+		} `json:"data"`
+	}
+	fields := map[string]string{"image_type": "message"}
+	if err := f.upload(ctx, "images", imagesURI, fields, "image", name, data, &result); err != nil {
+		return "", err
+	}
+	return result.Data.ImageKey, nil
+}
+
+// UploadFile uploads file with credentials of app and returns its file_key
+// https://open.feishu.cn/document/uAjLw4CM/ukTMukTMukTM/reference/im-v1/file/create
+func (f *Feishu) UploadFile(ctx context.Context, name string, data []byte) (string, error) {
+	if len(data) > MaxFeishuFileSize {
+		return "", fmt.Errorf("file %s exceeds %d bytes", name, MaxFeishuFileSize)
+	}
+	fileType, ok := fileTypes[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		fileType = "stream"
+	}
+	var result struct {
+		Data struct {
+			FileKey string `json:"file_key"`
+		} `json:"data"`
+	}
+	fields := map[string]string{"file_type": fileType, "file_name": filepath.Base(name)}
+	if err := f.upload(ctx, "files", filesURI, fields, "file", name, data, &result); err != nil {
+		return "", err
+	}
+	return result.Data.FileKey, nil
+}
+
+// upload posts fields and file as multipart form to uri, it's only available to apps
+func (f *Feishu) upload(ctx context.Context, api, uri string, fields map[string]string, field, name string, data []byte, out interface{}) error {
+	if !f.IsApp() {
+		return errors.New("uploading is only available with credentials of app")
+	}
+	buf := bytes.NewBuffer(nil)
+	mw := multipart.NewWriter(buf)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	fw, err := mw.CreateFormFile(field, filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	if _, err = fw.Write(data); err != nil {
+		return err
+	}
+	if err = mw.Close(); err != nil {
+		return err
+	}
+	return withRetry(ctx, limiterFor("feishu-app", f.AppID, appRateLimit, appRateBurst), func() error {
+		token, err := getTenantAccessToken(ctx, f.AppID, f.AppSecret)
+		if err != nil {
+			return err
+		}
+		header := http.Header{"Authorization": []string{"Bearer " + token}}
+		err = post(ctx, endpoint{"feishu", api}, uri, header, mw.FormDataContentType(), buf.Bytes(), checkFeishu(out))
+		if e, ok := err.(*ResponseError); ok && (e.Code == codeAccessTokenInvalid || e.Code == codeAccessTokenExpired) {
+			invalidateTenantAccessToken(f.AppID)
+		}
+		return err
+	})
+}
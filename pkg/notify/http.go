@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/fengxsong/toolkit/pkg/log"
+)
+
+const defaultRetryMaxDelay = 10 * time.Second
+
+// RequestDuration observes latencies of requests to webhooks and apis of channels,
+// it's up to callers to register it for exposing
+var RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "notify",
+	Name:      "request_duration_seconds",
+	Help:      "Latencies of requests to webhooks and apis of channels.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"channel", "api", "status"})
+
+var (
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+
+	limiterMu sync.Mutex
+	limiters  = make(map[string]*rate.Limiter)
+)
+
+// limiterFor returns the rate limiter of backend by key, eg. token of feishu bot.
+// Limiters are scoped by backend since keys of different backends may collide.
+func limiterFor(backend, key string, limit rate.Limit, burst int) *rate.Limiter {
+	key = backend + "/" + key
+	limiterMu.Lock()
+	defer limiterMu.Unlock()
+	l, ok := limiters[key]
+	if !ok {
+		l = rate.NewLimiter(limit, burst)
+		limiters[key] = l
+	}
+	return l
+}
+
+// ResponseError represents an unsuccessful response of webhook or api
+type ResponseError struct {
+	StatusCode int
+	Code       int
+	Msg        string
+	// Temporary reports whether it's rate limited or server is unavailable
+	Temporary bool
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("unexpected response(status=%d, code=%d): %s", e.StatusCode, e.Code, e.Msg)
+}
+
+func isRetryable(err error) bool {
+	if e, ok := err.(*ResponseError); ok {
+		return e.Temporary || e.StatusCode == http.StatusTooManyRequests || e.StatusCode/100 == 5
+	}
+	// only transport errors are retried, eg. errors of sending requests or reading
+	// responses, failures of encoding requests or decoding responses are not
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// withRetry calls fn throttled by limiter, and retries with backoff when it's
+// rate limited or server is unavailable
+func withRetry(ctx context.Context, limiter *rate.Limiter, fn func() error) error {
+	delay := 500 * time.Millisecond
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if !isRetryable(err) || delay > defaultRetryMaxDelay {
+			return err
+		}
+		log.GetLogger().Warnf("error occur: %v, retrying in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// checkFunc checks response and returns *ResponseError if it's unsuccessful
+type checkFunc func(statusCode int, body []byte) error
+
+// endpoint identifies api of channel in metrics
+type endpoint struct {
+	channel string
+	api     string
+}
+
+// postJSON posts in as JSON to uri with headers, and checks response with check
+func postJSON(ctx context.Context, ep endpoint, uri string, header http.Header, in interface{}, check checkFunc) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return post(ctx, ep, uri, header, "application/json; charset=utf-8", b, check)
+}
+
+// post posts body to uri with headers, and checks response with check
+func post(ctx context.Context, ep endpoint, uri string, header http.Header, contentType string, body []byte, check checkFunc) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		RequestDuration.WithLabelValues(ep.channel, ep.api, "error").Observe(time.Since(start).Seconds())
+		return err
+	}
+	defer resp.Body.Close()
+	RequestDuration.WithLabelValues(ep.channel, ep.api, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	respContent, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return check(resp.StatusCode, respContent)
+}
+
+// checkErrCode checks responses in format {"errcode":0,"errmsg":"ok"}, which are
+// returned by dingtalk and wecom
+func checkErrCode(temporaryCodes ...int) checkFunc {
+	return func(statusCode int, body []byte) error {
+		var result struct {
+			ErrCode int    `json:"errcode"`
+			ErrMsg  string `json:"errmsg"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			if statusCode/100 != 2 {
+				return &ResponseError{StatusCode: statusCode, Msg: string(body)}
+			}
+			return fmt.Errorf("decode response: %v", err)
+		}
+		if result.ErrCode == 0 && statusCode/100 == 2 {
+			return nil
+		}
+		e := &ResponseError{StatusCode: statusCode, Code: result.ErrCode, Msg: result.ErrMsg}
+		for _, code := range temporaryCodes {
+			if code == result.ErrCode {
+				e.Temporary = true
+			}
+		}
+		return e
+	}
+}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Message is a channel agnostic message
+type Message struct {
+	Title string
+	// Text is the body of message, it's rendered as markdown by channels that support it
+	Text string
+	// At is a list of user ids to mention, `all` for everyone
+	At []string
+}
+
+// Notifier sends messages to a channel
+type Notifier interface {
+	Notify(ctx context.Context, msg *Message) error
+}
+
+// Factory creates notifier from config, unmarshal decodes config into a struct
+type Factory func(unmarshal func(interface{}) error) (Notifier, error)
+
+var factories = make(map[string]Factory)
+
+// Register registers factory of notifier type
+func Register(typ string, f Factory) {
+	if _, ok := factories[typ]; ok {
+		panic("notifier type already exists")
+	}
+	factories[typ] = f
+}
+
+// Types returns registered notifier types
+func Types() []string {
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New creates notifier of type
+func New(typ string, unmarshal func(interface{}) error) (Notifier, error) {
+	f, ok := factories[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown notifier type: %s", typ)
+	}
+	return f(unmarshal)
+}
+
+// channelConfig is config of a channel, fields except `type` are decoded by factory of the type
+type channelConfig struct {
+	Type string
+	node *yaml.Node
+}
+
+func (c *channelConfig) UnmarshalYAML(value *yaml.Node) error {
+	var t struct {
+		Type string `yaml:"type"`
+	}
+	if err := value.Decode(&t); err != nil {
+		return err
+	}
+	c.Type, c.node = t.Type, value
+	return nil
+}
+
+// Config of channels, eg.
+//
+//	channels:
+//	  ops-feishu:
+//	    type: feishu
+//	    token: xxx
+//	  ops-dingtalk:
+//	    type: dingtalk
+//	    token: xxx
+//	    secret: yyy
+type Config struct {
+	Channels map[string]*channelConfig `yaml:"channels"`
+}
+
+// LoadConfig loads channels from config file and creates notifiers of them
+func LoadConfig(fn string) (map[string]Notifier, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %v", fn, err)
+	}
+	notifiers := make(map[string]Notifier, len(cfg.Channels))
+	for name, c := range cfg.Channels {
+		if c == nil {
+			return nil, fmt.Errorf("channel %s is empty", name)
+		}
+		n, err := New(c.Type, c.node.Decode)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %v", name, err)
+		}
+		notifiers[name] = n
+	}
+	return notifiers, nil
+}
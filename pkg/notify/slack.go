@@ -0,0 +1,63 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// incoming webhook allows one message per second, with short bursts
+	slackRateLimit = rate.Limit(1)
+	slackRateBurst = 5
+)
+
+func init() {
+	Register("slack", func(unmarshal func(interface{}) error) (Notifier, error) {
+		s := &Slack{}
+		if err := unmarshal(s); err != nil {
+			return nil, err
+		}
+		if s.URL == "" {
+			return nil, errors.New("url is required")
+		}
+		return s, nil
+	})
+}
+
+// Slack is an incoming webhook of slack
+// https://api.slack.com/messaging/webhooks
+type Slack struct {
+	URL string `yaml:"url"`
+}
+
+// Notify sends message in mrkdwn, title is rendered in bold. Mentions are member ids.
+func (s *Slack) Notify(ctx context.Context, msg *Message) error {
+	var sb strings.Builder
+	if msg.Title != "" {
+		fmt.Fprintf(&sb, "*%s*\n", msg.Title)
+	}
+	sb.WriteString(msg.Text)
+	for _, id := range msg.At {
+		if id == "all" {
+			sb.WriteString(" <!channel>")
+			continue
+		}
+		fmt.Fprintf(&sb, " <@%s>", id)
+	}
+	pl := map[string]string{"text": sb.String()}
+	return withRetry(ctx, limiterFor("slack", s.URL, slackRateLimit, slackRateBurst), func() error {
+		return postJSON(ctx, endpoint{"slack", "webhook"}, s.URL, nil, pl, checkSlack)
+	})
+}
+
+// checkSlack checks response of incoming webhook, which is plain text `ok` on success
+func checkSlack(statusCode int, body []byte) error {
+	if statusCode/100 == 2 {
+		return nil
+	}
+	return &ResponseError{StatusCode: statusCode, Msg: strings.TrimSpace(string(body))}
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	wecomWebhookURI = "https://qyapi.weixin.qq.com/cgi-bin/webhook/send"
+	// group robot is limited to 20 messages per minute
+	wecomRateLimit = rate.Limit(20.0 / 60)
+	wecomRateBurst = 5
+
+	codeWecomFreqOutOfLimit = 45009
+)
+
+func init() {
+	Register("wecom", func(unmarshal func(interface{}) error) (Notifier, error) {
+		w := &WeCom{}
+		if err := unmarshal(w); err != nil {
+			return nil, err
+		}
+		if w.Key == "" {
+			return nil, errors.New("key is required")
+		}
+		return w, nil
+	})
+}
+
+// WeCom is a group robot of wecom(wechat work)
+// https://developer.work.weixin.qq.com/document/path/91770
+type WeCom struct {
+	Key string `yaml:"key"`
+}
+
+// Notify sends message as markdown if it has title, otherwise as text. Mentions
+// are userids of members.
+func (w *WeCom) Notify(ctx context.Context, msg *Message) error {
+	var pl map[string]interface{}
+	if msg.Title != "" {
+		// markdown message doesn't support mentioned_list, mention in content instead
+		text := fmt.Sprintf("### %s\n%s", msg.Title, msg.Text)
+		for _, id := range msg.At {
+			if id == "all" {
+				id = "@all"
+			}
+			text += fmt.Sprintf(" <@%s>", id)
+		}
+		pl = map[string]interface{}{
+			"msgtype":  "markdown",
+			"markdown": map[string]string{"content": text},
+		}
+	} else {
+		mentioned := make([]string, 0, len(msg.At))
+		for _, id := range msg.At {
+			if id == "all" {
+				id = "@all"
+			}
+			mentioned = append(mentioned, id)
+		}
+		pl = map[string]interface{}{
+			"msgtype": "text",
+			"text":    map[string]interface{}{"content": msg.Text, "mentioned_list": mentioned},
+		}
+	}
+	uri := wecomWebhookURI + "?" + url.Values{"key": []string{w.Key}}.Encode()
+	return withRetry(ctx, limiterFor("wecom", w.Key, wecomRateLimit, wecomRateBurst), func() error {
+		return postJSON(ctx, endpoint{"wecom", "webhook"}, uri, nil, pl, checkErrCode(codeWecomFreqOutOfLimit))
+	})
+}
@@ -0,0 +1,30 @@
+package template
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// FuncMap returns functions shared by templates of commands, callers may add
+// their own functions to the returned map
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"env":   os.Getenv,
+		"now":   time.Now,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"default": func(def interface{}, val interface{}) interface{} {
+			if val == nil || val == "" {
+				return def
+			}
+			return val
+		},
+		"toJSON": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}